@@ -0,0 +1,243 @@
+package mpthSim
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time used by Links and Nodes. RealClock follows the
+// wall clock, while a VirtualClock runs a discrete-event scheduler that jumps
+// from one timer to the next as soon as every participant is idle.
+//
+// To let a VirtualClock know when the simulation is idle, the goroutines that
+// take part in it follow a simple accounting rule: Hold is called before
+// handing work to another goroutine (a packet sent through a channel, or a new
+// goroutine started with Go), and Release is called once that work has been
+// handled. Sleep releases the caller while it waits and holds it again when it
+// wakes up.
+//
+// Every timer is set on behalf of an Owner, see Owner, or of none if o is nil.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Since returns the time elapsed since t
+	Since(t time.Time) time.Duration
+	// Sleep blocks the calling goroutine for the duration d
+	Sleep(o *Owner, d time.Duration)
+	// AfterFunc waits for the duration d to elapse and then calls f in its own
+	// goroutine, accounted as busy until f returns. Unlike with Sleep, the
+	// timer is set before AfterFunc returns.
+	AfterFunc(o *Owner, d time.Duration, f func())
+	// Go runs f in a new goroutine accounted as busy until f returns
+	Go(f func())
+	// Hold marks one more unit of pending work
+	Hold()
+	// Release marks one unit of pending work as handled
+	Release()
+}
+
+// Owner sets timers on behalf of one party of the simulation, e.g., a link or
+// the sender of a node, one at a time. A VirtualClock fires the timers that
+// expire at the same instant by owner, in the order the owners were created,
+// and then in the order each owner set them, so that the order does not depend
+// on how the goroutines of the owners are scheduled. The owners must be
+// created in a deterministic order for that, e.g., while the simulation is
+// built or by the goroutine the clock wakes up.
+type Owner struct {
+	id  uint64
+	seq uint64 // Guarded by the clock
+}
+
+// owners counts the owners created so far
+var owners atomic.Uint64
+
+// NewOwner creates an Owner ordered after every one created before
+func NewOwner() *Owner {
+	return &Owner{id: owners.Add(1)}
+}
+
+// RealClock is a Clock that follows the wall clock. Hold and Release are no-ops.
+type RealClock struct{}
+
+// Now returns time.Now()
+func (RealClock) Now() time.Time { return time.Now() }
+
+// Since returns time.Since(t)
+func (RealClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Sleep waits for d in real time
+func (RealClock) Sleep(o *Owner, d time.Duration) { <-time.After(d) }
+
+// AfterFunc calls f in its own goroutine after d in real time
+func (RealClock) AfterFunc(o *Owner, d time.Duration, f func()) { time.AfterFunc(d, f) }
+
+// Go runs f in a new goroutine
+func (RealClock) Go(f func()) { go f() }
+
+// Hold does nothing for a real clock
+func (RealClock) Hold() {}
+
+// Release does nothing for a real clock
+func (RealClock) Release() {}
+
+// VirtualClock is a Clock that simulates the passage of time. Timers are kept
+// in a queue ordered by expiration time and are fired one at a time whenever
+// there is no pending work left, so a run takes as long as the computation
+// needs instead of the delays it models. Timers expiring at the same instant
+// fire by Owner, the ones without an owner first in the order they were set.
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	busy   int
+	seq    uint64
+	timers timerQueue
+}
+
+// NewVirtualClock creates a virtual clock whose current time is start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := new(VirtualClock)
+	c.now = start
+	return c
+}
+
+// Now returns the current virtual time
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the virtual time elapsed since t
+func (c *VirtualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Sleep sets a timer that expires after d, releases the caller and blocks
+// until the timer fires. The caller is held again when it wakes up.
+func (c *VirtualClock) Sleep(o *Owner, d time.Duration) {
+	c.mu.Lock()
+	t := c.setTimer(o, d)
+	t.wake = make(chan struct{})
+	c.busy--
+	c.advance()
+	c.mu.Unlock()
+
+	<-t.wake
+}
+
+// AfterFunc sets a timer that expires after d and calls f in its own goroutine
+// when it fires. The goroutine is held until f returns.
+func (c *VirtualClock) AfterFunc(o *Owner, d time.Duration, f func()) {
+	c.mu.Lock()
+	t := c.setTimer(o, d)
+	t.f = f
+	c.mu.Unlock()
+}
+
+// setTimer queues a timer of the owner o that expires after d. c.mu must be
+// held.
+func (c *VirtualClock) setTimer(o *Owner, d time.Duration) *timer {
+	if d < 0 {
+		d = 0
+	}
+	t := &timer{when: c.now.Add(d)}
+	if o != nil {
+		t.owner, t.seq = o.id, o.seq
+		o.seq++
+	} else {
+		t.seq = c.seq
+		c.seq++
+	}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+// Go holds the clock, runs f in a new goroutine and releases the clock when f
+// returns
+func (c *VirtualClock) Go(f func()) {
+	c.Hold()
+	go func() {
+		defer c.Release()
+		f()
+	}()
+}
+
+// Hold marks one more unit of pending work. Time does not advance while there
+// is pending work.
+func (c *VirtualClock) Hold() {
+	c.mu.Lock()
+	c.busy++
+	c.mu.Unlock()
+}
+
+// Release marks one unit of pending work as handled. When no work is left, the
+// earliest timer fires.
+func (c *VirtualClock) Release() {
+	c.mu.Lock()
+	c.busy--
+	c.advance()
+	c.mu.Unlock()
+}
+
+// advance fires the earliest timer if the simulation is idle. The woken
+// goroutine is held on its behalf before it runs. c.mu must be held.
+func (c *VirtualClock) advance() {
+	if c.busy < 0 {
+		panic("mpthSim: VirtualClock released more times than held")
+	}
+	if c.busy > 0 || len(c.timers) == 0 {
+		return
+	}
+	t := heap.Pop(&c.timers).(*timer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.busy++
+	if t.f != nil {
+		go func() {
+			defer c.Release()
+			t.f()
+		}()
+		return
+	}
+	close(t.wake)
+}
+
+// timer wakes up a sleeping goroutine or calls f when it fires
+type timer struct {
+	when  time.Time
+	owner uint64 // ID of the Owner, or 0 if none
+	seq   uint64
+	wake  chan struct{}
+	f     func()
+}
+
+// timerQueue implements heap.Interface ordered by expiration time, then by
+// owner and by the order in which each owner set the timers
+type timerQueue []*timer
+
+func (q timerQueue) Len() int { return len(q) }
+
+func (q timerQueue) Less(i, j int) bool {
+	switch {
+	case !q[i].when.Equal(q[j].when):
+		return q[i].when.Before(q[j].when)
+	case q[i].owner != q[j].owner:
+		return q[i].owner < q[j].owner
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q timerQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *timerQueue) Push(x interface{}) { *q = append(*q, x.(*timer)) }
+
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return t
+}
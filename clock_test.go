@@ -0,0 +1,120 @@
+package mpthSim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClockSleep(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+	woke := make(chan time.Time)
+	c.Go(func() {
+		c.Sleep(nil, 3*time.Second)
+		c.Sleep(nil, 2*time.Second)
+		woke <- c.Now()
+	})
+	if got := (<-woke).Sub(start); got != 5*time.Second {
+		t.Errorf("woke up after %v, want 5s", got)
+	}
+}
+
+func TestVirtualClockAfterFuncOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+	type firing struct {
+		id int
+		at time.Duration
+	}
+	fired := make(chan firing, 4)
+	c.Hold() // Set every timer before the time advances
+	for id, d := range []time.Duration{2 * time.Second, time.Second, 2 * time.Second, 0} {
+		id := id
+		c.AfterFunc(nil, d, func() { fired <- firing{id, c.Since(start)} })
+	}
+	c.Release()
+
+	// Timers expiring at the same instant fire in the order they were set
+	want := []firing{{3, 0}, {1, time.Second}, {0, 2 * time.Second}, {2, 2 * time.Second}}
+	for i, w := range want {
+		if got := <-fired; got != w {
+			t.Errorf("firing %d: got timer %d at %v, want timer %d at %v", i, got.id, got.at, w.id, w.at)
+		}
+	}
+}
+
+func TestVirtualClockOwnerOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+	a, b := NewOwner(), NewOwner()
+	fired := make(chan string, 4)
+	c.Hold()
+	c.AfterFunc(b, time.Second, func() { fired <- "b0" })
+	c.AfterFunc(nil, time.Second, func() { fired <- "none" })
+	c.AfterFunc(a, time.Second, func() { fired <- "a0" })
+	c.AfterFunc(a, time.Second, func() { fired <- "a1" })
+	c.Release()
+
+	// By owner in the order they were created, whatever the order of the
+	// timers, and the ones without an owner first
+	for i, want := range []string{"none", "a0", "a1", "b0"} {
+		if got := <-fired; got != want {
+			t.Errorf("firing %d: got timer %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestVirtualClockHoldStopsTime(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+	fired := make(chan struct{})
+	c.Hold()
+	c.Hold()
+	c.AfterFunc(nil, time.Second, func() { close(fired) })
+	c.Release()
+
+	// Still held once, so the timer must stay queued
+	c.mu.Lock()
+	queued, now := len(c.timers), c.now
+	c.mu.Unlock()
+	if queued != 1 || !now.Equal(start) {
+		t.Fatalf("with pending work: %d timers queued at %v, want 1 at %v", queued, now, start)
+	}
+
+	c.Release()
+	<-fired
+	if got := c.Since(start); got != time.Second {
+		t.Errorf("timer fired at %v, want 1s", got)
+	}
+}
+
+func TestVirtualClockGoHoldsUntilReturn(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewVirtualClock(start)
+	proceed := make(chan struct{})
+	fired := make(chan time.Duration)
+	c.AfterFunc(nil, time.Second, func() { fired <- c.Since(start) })
+	c.Hold() // Keep the timer from firing until Go holds the clock
+	c.Go(func() { <-proceed })
+	c.Release()
+
+	c.mu.Lock()
+	busy := c.busy
+	c.mu.Unlock()
+	if busy != 1 {
+		t.Fatalf("busy = %d while the goroutine runs, want 1", busy)
+	}
+	close(proceed)
+	if got := <-fired; got != time.Second {
+		t.Errorf("timer fired at %v, want 1s", got)
+	}
+}
+
+func TestVirtualClockReleaseWithoutHoldPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Release without Hold did not panic")
+		}
+	}()
+	NewVirtualClock(time.Unix(0, 0)).Release()
+}
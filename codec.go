@@ -0,0 +1,182 @@
+package mpthSim
+
+import (
+	"github.com/JuanCabre/mpthSim/rlnc"
+)
+
+// payloadWriter is what a node needs to send coded payloads, both from an
+// encoder and from a recoder
+type payloadWriter interface {
+	// WritePayload writes a coded payload to the beginning of the buffer,
+	// which must hold at least PayloadSize bytes, and returns its size
+	WritePayload([]byte) uint32
+	PayloadSize() uint32
+	Rank() uint32
+}
+
+// Encoder is the codec of an encoder node
+type Encoder interface {
+	payloadWriter
+	SymbolSize() uint32
+	BlockSize() uint32
+	// SetConstSymbols sets the block of BlockSize bytes to encode
+	SetConstSymbols([]byte)
+}
+
+// Decoder is the codec of decoder and recoder nodes. Its WritePayload recodes
+// the payloads read so far.
+type Decoder interface {
+	payloadWriter
+	SymbolSize() uint32
+	BlockSize() uint32
+	// SetMutableSymbols sets the buffer of BlockSize bytes where the block is
+	// decoded
+	SetMutableSymbols([]byte)
+	// ReadPayload reads a coded payload
+	ReadPayload([]byte)
+	IsComplete() bool
+}
+
+// EncoderFactory builds encoders with the same parameters
+type EncoderFactory interface {
+	Build() Encoder
+}
+
+// DecoderFactory builds decoders with the same parameters
+type DecoderFactory interface {
+	Build() Decoder
+}
+
+// systematicEncoder is implemented by the encoders that can send the symbols
+// uncoded before the coded ones, like the ones of package rlnc and kodo
+type systematicEncoder interface {
+	SetSystematicOn()
+	SetSystematicOff()
+	InSystematicPhase() bool
+}
+
+// windowEncoder is implemented by the encoders that code a stream over a
+// sliding window, like the rlnc.WindowEncoder, instead of a block
+type windowEncoder interface {
+	// Slide moves the beginning of the window to the first symbol that is not
+	// delivered yet
+	Slide(lo uint32)
+	Window() (lo, hi uint32)
+}
+
+// windowDecoder is implemented by the decoders of a stream coded over a
+// sliding window, which deliver the symbols in order as they are decoded
+type windowDecoder interface {
+	Delivered() uint32
+}
+
+// deleter is implemented by the codecs that must free their memory
+// explicitly, e.g., the kodo ones
+type deleter interface {
+	Delete()
+}
+
+// RLNCEncoderFactory builds the pure-Go RLNC encoders of package rlnc. A
+// non-zero Density builds sparse encoders and a non-zero Width perpetual ones,
+// which the same decoders decode. Encoding is the way their payloads carry the
+// coefficients, which the decoders must share.
+type RLNCEncoderFactory struct {
+	Field               rlnc.Field
+	Symbols, SymbolSize uint32
+	Density             float64
+	Width               uint32
+	Encoding            rlnc.Encoding
+}
+
+// NewRLNCEncoderFactory creates a factory of encoders for blocks of the given
+// number of symbols of symbolSize bytes over the field
+func NewRLNCEncoderFactory(field rlnc.Field, symbols, symbolSize uint32) *RLNCEncoderFactory {
+	return &RLNCEncoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize}
+}
+
+// NewRLNCSparseEncoderFactory creates a factory of sparse encoders whose
+// coefficients are non-zero with probability density
+func NewRLNCSparseEncoderFactory(field rlnc.Field, symbols, symbolSize uint32, density float64) *RLNCEncoderFactory {
+	return &RLNCEncoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize, Density: density}
+}
+
+// NewRLNCPerpetualEncoderFactory creates a factory of perpetual encoders that
+// code a random pivot with the width symbols after it
+func NewRLNCPerpetualEncoderFactory(field rlnc.Field, symbols, symbolSize, width uint32) *RLNCEncoderFactory {
+	return &RLNCEncoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize, Width: width}
+}
+
+// Build creates a new encoder
+func (f *RLNCEncoderFactory) Build() Encoder {
+	var e *rlnc.Encoder
+	switch {
+	case f.Density > 0:
+		e = rlnc.NewSparseEncoder(f.Field, f.Symbols, f.SymbolSize, f.Density)
+	case f.Width > 0:
+		e = rlnc.NewPerpetualEncoder(f.Field, f.Symbols, f.SymbolSize, f.Width)
+	default:
+		e = rlnc.NewEncoder(f.Field, f.Symbols, f.SymbolSize)
+	}
+	e.SetEncoding(f.Encoding)
+	return e
+}
+
+// RLNCDecoderFactory builds the pure-Go RLNC decoders of package rlnc, which
+// read the payloads with the Encoding of the encoders
+type RLNCDecoderFactory struct {
+	Field               rlnc.Field
+	Symbols, SymbolSize uint32
+	Encoding            rlnc.Encoding
+}
+
+// NewRLNCDecoderFactory creates a factory of decoders for blocks of the given
+// number of symbols of symbolSize bytes over the field
+func NewRLNCDecoderFactory(field rlnc.Field, symbols, symbolSize uint32) *RLNCDecoderFactory {
+	return &RLNCDecoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize}
+}
+
+// Build creates a new decoder
+func (f *RLNCDecoderFactory) Build() Decoder {
+	d := rlnc.NewDecoder(f.Field, f.Symbols, f.SymbolSize)
+	d.SetEncoding(f.Encoding)
+	return d
+}
+
+// RLNCWindowEncoderFactory builds the pure-Go sliding-window RLNC encoders of
+// package rlnc. Symbols is the length of the whole stream and Window the
+// maximum number of symbols coded together.
+type RLNCWindowEncoderFactory struct {
+	Field                       rlnc.Field
+	Symbols, SymbolSize, Window uint32
+}
+
+// NewRLNCWindowEncoderFactory creates a factory of encoders for streams of the
+// given number of symbols of symbolSize bytes, coded over a window of at most
+// window symbols
+func NewRLNCWindowEncoderFactory(field rlnc.Field, symbols, symbolSize, window uint32) *RLNCWindowEncoderFactory {
+	return &RLNCWindowEncoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize, Window: window}
+}
+
+// Build creates a new encoder
+func (f *RLNCWindowEncoderFactory) Build() Encoder {
+	return rlnc.NewWindowEncoder(f.Field, f.Symbols, f.SymbolSize, f.Window)
+}
+
+// RLNCWindowDecoderFactory builds the pure-Go sliding-window RLNC decoders of
+// package rlnc
+type RLNCWindowDecoderFactory struct {
+	Field                       rlnc.Field
+	Symbols, SymbolSize, Window uint32
+}
+
+// NewRLNCWindowDecoderFactory creates a factory of decoders for streams of the
+// given number of symbols of symbolSize bytes, coded over a window of at most
+// window symbols
+func NewRLNCWindowDecoderFactory(field rlnc.Field, symbols, symbolSize, window uint32) *RLNCWindowDecoderFactory {
+	return &RLNCWindowDecoderFactory{Field: field, Symbols: symbols, SymbolSize: symbolSize, Window: window}
+}
+
+// Build creates a new decoder
+func (f *RLNCWindowDecoderFactory) Build() Decoder {
+	return rlnc.NewWindowDecoder(f.Field, f.Symbols, f.SymbolSize, f.Window)
+}
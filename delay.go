@@ -0,0 +1,36 @@
+package mpthSim
+
+import (
+	"time"
+
+	"github.com/JuanCabre/mpthSim/geo"
+)
+
+// DelayModel gives the one-way delay of the packets entering a Link
+type DelayModel interface {
+	// Delay returns the delay of the packet entering the link at time now
+	Delay(now time.Time) time.Duration
+}
+
+// ConstDelay is a delay model where every packet takes the same time to cross
+// the link
+type ConstDelay time.Duration
+
+// Delay returns d for every packet
+func (d ConstDelay) Delay(now time.Time) time.Duration {
+	return time.Duration(d)
+}
+
+// GeometricDelay is a delay model where the delay is the propagation time
+// between the positions of the two endpoints of the link, e.g., a ground
+// station and a satellite, when the packet enters the link
+type GeometricDelay struct {
+	From, To geo.Positioner
+	// Processing is a fixed delay added to the propagation delay
+	Processing time.Duration
+}
+
+// Delay returns the propagation delay between the endpoints at time now
+func (g *GeometricDelay) Delay(now time.Time) time.Duration {
+	return g.Processing + geo.PropagationDelay(g.From, g.To, now)
+}
@@ -1,23 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
 
-	"gitlab.com/steinwurf/kodo-go/src/kodo"
-
 	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/rlnc"
 )
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+	ctx := context.Background()
 
 	// The links
 	l1 := mpthSim.NewLink(0.5, 100*time.Millisecond)
-	go l1.ProcessPackets()
+	go l1.ProcessPackets(ctx)
 	l2 := mpthSim.NewLink(0, 100*time.Millisecond)
-	go l2.ProcessPackets()
+	go l2.ProcessPackets(ctx)
 
 	// The coders
 	// Set the number of symbols (i.e. the generation size in RLNC
@@ -25,14 +26,8 @@ func main() {
 	var symbols, symbolSize uint32 = 30, 100
 
 	// Initialization of encoder and decoder
-	encoderFactory := kodo.NewEncoderFactory(kodo.FullVector,
-		kodo.Binary8, symbols, symbolSize)
-	decoderFactory := kodo.NewDecoderFactory(kodo.FullVector,
-		kodo.Binary8, symbols, symbolSize)
-
-	// These lines show the API to clean the memory used by the factories
-	defer kodo.DeleteEncoderFactory(encoderFactory)
-	defer kodo.DeleteDecoderFactory(decoderFactory)
+	encoderFactory := mpthSim.NewRLNCEncoderFactory(rlnc.Binary8, symbols, symbolSize)
+	decoderFactory := mpthSim.NewRLNCDecoderFactory(rlnc.Binary8, symbols, symbolSize)
 
 	encoderNode := mpthSim.NewEncoderNode(encoderFactory, 1000)
 	encoderNode.AddOutput(l1)
@@ -45,7 +40,7 @@ func main() {
 	decoderNode := mpthSim.NewDecoderNode(decoderFactory, 1000)
 	decoderNode.AddInput(l1)
 
-	go encoderNode.SendEncodedPackets()
+	go encoderNode.SendEncodedPackets(ctx)
 
 	go func() {
 		<-time.After(5 * time.Second)
@@ -53,11 +48,12 @@ func main() {
 		decoderNode.AddInput(l2)
 	}()
 
-	decoderNode.ReceiveCodedPackets(encoderNode.Done)
+	decoderNode.ReceiveCodedPackets(ctx)
 
 	decoderNode.InputsWg.Wait()
-	fmt.Println("l1 in : ", l1.InCount, "|| l1 out: ", l1.OutCount, "|| l1 losses: ", l1.LostCount)
-	fmt.Println("l2 in : ", l2.InCount, "|| l2 out: ", l2.OutCount, "|| l2 losses: ", l2.LostCount)
+	s1, s2 := l1.Stats(), l2.Stats()
+	fmt.Println("l1 in : ", s1.In, "|| l1 out: ", s1.Out, "|| l1 losses: ", s1.Lost)
+	fmt.Println("l2 in : ", s2.In, "|| l2 out: ", s2.Out, "|| l2 losses: ", s2.Lost)
 
 	// Check if we properly decoded the data
 	for i, v := range encoderNode.Data {
@@ -1,14 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 
-	"gitlab.com/steinwurf/kodo-go/src/kodo"
-
 	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/rlnc"
 )
 
 func main() {
@@ -27,22 +27,23 @@ func main() {
 
 	var errorProb float64 = 0
 	linkDelay := 3000 * time.Millisecond
+	ctx := context.Background()
 
 	// The links
 	l1 := mpthSim.NewLink(errorProb, linkDelay)
-	go l1.ProcessPackets()
+	go l1.ProcessPackets(ctx)
 	l2 := mpthSim.NewLink(errorProb, linkDelay)
-	go l2.ProcessPackets()
+	go l2.ProcessPackets(ctx)
 
 	l3 := mpthSim.NewLink(errorProb, 2*linkDelay)
-	go l3.ProcessPackets()
+	go l3.ProcessPackets(ctx)
 	l4 := mpthSim.NewLink(errorProb, 2*linkDelay)
-	go l4.ProcessPackets()
+	go l4.ProcessPackets(ctx)
 
 	l5 := mpthSim.NewLink(errorProb, 4*linkDelay)
-	go l5.ProcessPackets()
+	go l5.ProcessPackets(ctx)
 	l6 := mpthSim.NewLink(errorProb, 4*linkDelay)
-	go l6.ProcessPackets()
+	go l6.ProcessPackets(ctx)
 
 	// The coders
 	// Set the number of symbols (i.e. the generation size in RLNC
@@ -51,14 +52,8 @@ func main() {
 	var rate uint64 = 5000
 
 	// Initialization of encoder and decoder
-	encoderFactory := kodo.NewEncoderFactory(kodo.FullVector,
-		kodo.Binary8, symbols, symbolSize)
-	decoderFactory := kodo.NewDecoderFactory(kodo.FullVector,
-		kodo.Binary8, symbols, symbolSize)
-
-	// These lines show the API to clean the memory used by the factories
-	defer kodo.DeleteEncoderFactory(encoderFactory)
-	defer kodo.DeleteDecoderFactory(decoderFactory)
+	encoderFactory := mpthSim.NewRLNCEncoderFactory(rlnc.Binary8, symbols, symbolSize)
+	decoderFactory := mpthSim.NewRLNCDecoderFactory(rlnc.Binary8, symbols, symbolSize)
 
 	encoderNode := mpthSim.NewEncoderNode(encoderFactory, rate)
 	encoderNode.AddOutput(l1)
@@ -89,14 +84,17 @@ func main() {
 
 	var wg sync.WaitGroup
 	wg.Add(1)
-	// go decoderNode.ReceiveCodedPackets(&wg, encoderNode.Done, recoder1.Done, recoder2.Done, recoder3.Done)
-	go decoderNode.ReceiveCodedPackets(&wg, encoderNode.Done, recoder1.Done)
+	// The decoder stops the recoders and the encoder through the links
+	go func() {
+		defer wg.Done()
+		decoderNode.ReceiveCodedPackets(ctx)
+	}()
 
-	go recoder1.RecodeAndSend()
-	// go recoder2.RecodeAndSend()
-	// go recoder3.RecodeAndSend()
+	go recoder1.RecodeAndSend(ctx)
+	// go recoder2.RecodeAndSend(ctx)
+	// go recoder3.RecodeAndSend(ctx)
 
-	go encoderNode.SendEncodedPackets()
+	go encoderNode.SendEncodedPackets(ctx)
 
 	// Reset the recoder 1 after some time
 	// go func() {
@@ -105,9 +103,9 @@ func main() {
 	// 	recoder1.Reset(decoderFactory)
 
 	// 	l1 := mpthSim.NewLink(errorProb, linkDelay)
-	// 	go l1.ProcessPackets()
+	// 	go l1.ProcessPackets(ctx)
 	// 	l2 := mpthSim.NewLink(errorProb, linkDelay)
-	// 	go l2.ProcessPackets()
+	// 	go l2.ProcessPackets(ctx)
 
 	// 	<-time.After(time.Second)
 
@@ -115,7 +113,7 @@ func main() {
 	// 	encoderNode.AddOutput(l1)
 	// 	decoderNode.AddInput(l2)
 	// 	recoder1.AddOutput(l2)
-	// 	go recoder1.RecodeAndSend()
+	// 	go recoder1.RecodeAndSend(ctx)
 
 	// }()
 
@@ -129,10 +127,10 @@ func main() {
 		}
 	}
 	fmt.Println("Data decoded correctly")
-	fmt.Println("Encoder Transmissions: ", encoderNode.Transmissions)
-	fmt.Println("Recoder1 Transmissions: ", recoder1.Transmissions)
-	// fmt.Println("Recoder2 Transmissions: ", recoder2.Transmissions)
-	// fmt.Println("Recoder3 Transmissions: ", recoder3.Transmissions)
+	fmt.Println("Encoder Transmissions: ", encoderNode.Transmissions())
+	fmt.Println("Recoder1 Transmissions: ", recoder1.Transmissions())
+	// fmt.Println("Recoder2 Transmissions: ", recoder2.Transmissions())
+	// fmt.Println("Recoder3 Transmissions: ", recoder3.Transmissions())
 }
 
 // func Wrapper(f func(), name string, wg *sync.WaitGroup) {
@@ -0,0 +1,367 @@
+package mpthSim
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// A link may have a reverse Feedback link, with its own losses and delays,
+// through which the receiver tells the sender what it no longer needs. The
+// sender of such a link then decides whether a generation, or the whole
+// transfer, is done from the last feedback it received instead of asking the
+// receiver directly, so it keeps sending until the feedback arrives.
+//
+// With RankFeedback, the reports also carry the number of innovative payloads
+// of every generation in flight that the receiver got through the link. They
+// are independent combinations of what the sender holds, so once there are as
+// many as the rank of the sender, the receiver holds everything the sender can
+// contribute, and the sender skips the generation on the link until its own
+// rank grows.
+
+// feedback is what a node reports to the nodes that feed it. Every field only
+// grows, so a sender merges the reports it receives in any order, and a lost
+// report is made up for by any later one.
+type feedback struct {
+	done      bool              // The node no longer needs anything
+	below     uint32            // Every generation before it is done
+	gens      map[uint32]bool   // Done generations from below on
+	delivered uint32            // Symbols delivered in order, see window.go
+	ranks     map[uint32]uint32 // Innovative payloads received through the link
+	// Innovative payloads of every generation received through the link
+	innovative uint32
+}
+
+// feedbackHeaderSize is the size of the fixed fields of a feedback message,
+// which the done generations from below on follow
+const feedbackHeaderSize = 1 + 4 + 4 + 4 + 4
+
+// generationDone reports whether the generation g is done
+func (f *feedback) generationDone(g uint32) bool {
+	return g < f.below || f.gens[g]
+}
+
+// merge adds the report r to f
+func (f *feedback) merge(r feedback) {
+	f.done = f.done || r.done
+	if r.below > f.below {
+		f.below = r.below
+	}
+	if r.delivered > f.delivered {
+		f.delivered = r.delivered
+	}
+	if r.innovative > f.innovative {
+		f.innovative = r.innovative
+	}
+	for g := range r.gens {
+		if f.gens == nil {
+			f.gens = make(map[uint32]bool)
+		}
+		f.gens[g] = true
+	}
+	for g, rank := range r.ranks {
+		if f.ranks == nil {
+			f.ranks = make(map[uint32]uint32)
+		}
+		if rank > f.ranks[g] {
+			f.ranks[g] = rank
+		}
+	}
+	for f.gens[f.below] {
+		f.below++
+	}
+	for g := range f.gens {
+		if g < f.below {
+			delete(f.gens, g)
+		}
+	}
+	for g := range f.ranks {
+		if f.generationDone(g) {
+			delete(f.ranks, g)
+		}
+	}
+}
+
+// equal reports whether f and r report the same
+func (f *feedback) equal(r feedback) bool {
+	if f.done != r.done || f.below != r.below || f.delivered != r.delivered ||
+		f.innovative != r.innovative || len(f.gens) != len(r.gens) || len(f.ranks) != len(r.ranks) {
+		return false
+	}
+	for g := range f.gens {
+		if !r.gens[g] {
+			return false
+		}
+	}
+	for g, rank := range f.ranks {
+		if other, ok := r.ranks[g]; !ok || other != rank {
+			return false
+		}
+	}
+	return true
+}
+
+// marshal encodes the report as the done flag, below, delivered, innovative,
+// the number of done generations from below on and the generations, then the
+// number of ranks and the generation and rank of each, in big-endian order
+func (f *feedback) marshal() []byte {
+	gens := sortedGenerations(f.gens)
+	ranked := make(map[uint32]bool, len(f.ranks))
+	for g := range f.ranks {
+		ranked[g] = true
+	}
+	ranks := sortedGenerations(ranked)
+
+	msg := make([]byte, feedbackHeaderSize+4*len(gens)+4+8*len(ranks))
+	if f.done {
+		msg[0] = 1
+	}
+	binary.BigEndian.PutUint32(msg[1:], f.below)
+	binary.BigEndian.PutUint32(msg[5:], f.delivered)
+	binary.BigEndian.PutUint32(msg[9:], f.innovative)
+	binary.BigEndian.PutUint32(msg[13:], uint32(len(gens)))
+	b := msg[feedbackHeaderSize:]
+	for _, g := range gens {
+		binary.BigEndian.PutUint32(b, g)
+		b = b[4:]
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(ranks)))
+	b = b[4:]
+	for _, g := range ranks {
+		binary.BigEndian.PutUint32(b, g)
+		binary.BigEndian.PutUint32(b[4:], f.ranks[g])
+		b = b[8:]
+	}
+	return msg
+}
+
+// sortedGenerations returns the generations of the set gens in order
+func sortedGenerations(gens map[uint32]bool) []uint32 {
+	sorted := make([]uint32, 0, len(gens))
+	for g := range gens {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// unmarshalFeedback decodes a report encoded by marshal
+func unmarshalFeedback(msg []byte) feedback {
+	f := feedback{
+		done:       msg[0] == 1,
+		below:      binary.BigEndian.Uint32(msg[1:]),
+		delivered:  binary.BigEndian.Uint32(msg[5:]),
+		innovative: binary.BigEndian.Uint32(msg[9:]),
+		gens:       make(map[uint32]bool),
+		ranks:      make(map[uint32]uint32),
+	}
+	count := binary.BigEndian.Uint32(msg[13:])
+	b := msg[feedbackHeaderSize:]
+	for i := uint32(0); i < count; i++ {
+		f.gens[binary.BigEndian.Uint32(b)] = true
+		b = b[4:]
+	}
+	count = binary.BigEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		f.ranks[binary.BigEndian.Uint32(b)] = binary.BigEndian.Uint32(b[4:])
+		b = b[8:]
+	}
+	return f
+}
+
+// report builds the feedback of the node: what it has decoded, if it is a
+// decoder, or what every node it sends to no longer needs otherwise. n.mu
+// must be held.
+func (n *Node) report() feedback {
+	for n.downstreamDone(n.doneBelow) {
+		n.doneBelow++
+	}
+	// The generations reported by the outputs are the only ones that may be
+	// done downstream beyond the ones already known
+	for _, out := range n.OutputLinks {
+		for g := range out.fb.gens {
+			n.downstreamDone(g)
+		}
+	}
+	f := feedback{
+		done:      n.isDone(),
+		below:     n.doneBelow,
+		gens:      make(map[uint32]bool),
+		delivered: n.downstreamDelivered(),
+	}
+	for g, done := range n.doneGens {
+		if done && g >= f.below {
+			f.gens[g] = true
+		}
+	}
+	return f
+}
+
+// inputRank returns the number of innovative payloads of the generation g the
+// node received through the input in, or 0 if it does not report them, as the
+// feedback would with RankFeedback
+func (n *Node) inputRank(in *Link, g uint32) uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.RankFeedback {
+		return 0
+	}
+	return in.received[g]
+}
+
+// inputInnovative returns the number of innovative payloads the node received
+// through the input in, or 0 if it does not report them, as the feedback would
+// with RankFeedback
+func (n *Node) inputInnovative(in *Link) uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.RankFeedback {
+		return 0
+	}
+	return in.innovative
+}
+
+// outputRank returns the number of innovative payloads of the generation g the
+// node at the other end of the output out received from it, as far as the
+// node knows, or 0 if it does not report them. n.mu must be held.
+func (n *Node) outputRank(out *Link, g uint32) uint32 {
+	if out.Feedback != nil {
+		return out.fb.ranks[g]
+	}
+	if to := out.receiver(); to != nil {
+		return to.inputRank(out, g)
+	}
+	return 0
+}
+
+// sendFeedback sends the report of the node through the feedback link of each
+// input if it changed since the last one sent through it, or anyway if force
+// is set, e.g., when the node receives a payload it no longer needs and the
+// sender may have missed the previous report. n.mu must be held.
+func (n *Node) sendFeedback(force bool) {
+	var links []*Link
+	for _, in := range n.InputLinks {
+		if in.Feedback != nil && !in.feedbackClosed {
+			links = append(links, in)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	report := n.report()
+	for _, in := range links {
+		f := report
+		if n.RankFeedback {
+			f.innovative = in.innovative
+			f.ranks = make(map[uint32]uint32)
+			for g, count := range in.received {
+				if !report.generationDone(g) {
+					f.ranks[g] = count
+				}
+			}
+		}
+		if !force && in.reported.equal(f) {
+			continue
+		}
+		in.reported = f
+		n.Clock.Hold() // The link releases it
+		in.Feedback.In <- f.marshal()
+		n.FeedbackTransmissions.Add(1)
+	}
+}
+
+// readFeedback merges the reports received through the feedback link of the
+// output out until the link is closed. The node forwards what changed to the
+// nodes that feed it, and is done once every output reports it is done.
+func (n *Node) readFeedback(out *Link) {
+	for msg := range out.Feedback.Out {
+		n.mu.Lock()
+		out.fb.merge(unmarshalFeedback(msg))
+		done := !n.isDone() && n.outputsDone()
+		if done {
+			n.closeDone() // Reported along with the generations done
+		}
+		n.sendFeedback(false)
+		n.mu.Unlock()
+		if done {
+			n.finish()
+		}
+		n.Clock.Release()
+	}
+}
+
+// readPayload reads the coded payload of a packet with the header h into the
+// decoder d of its generation, and reports whether it brought anything new.
+// A sender that keeps sending what the node already has, e.g., the symbols of
+// a window it delivered, may have missed its last report. The innovative
+// payloads are counted per input for RankFeedback, and per generation too
+// except over a sliding window, whose rank depends on the window every node
+// holds. n.mu must be held.
+func (n *Node) readPayload(d Decoder, h Header, payload []byte) bool {
+	if h.Hops > n.hops {
+		n.hops = h.Hops
+	}
+	rank := d.Rank()
+	w, window := d.(windowDecoder)
+	var delivered uint32
+	if window {
+		delivered = w.Delivered()
+	}
+	d.ReadPayload(payload)
+	if d.Rank() == rank && (!window || w.Delivered() == delivered) {
+		return false
+	}
+	// The latest input of the path, the previous ones are gone
+	for i := len(n.InputLinks) - 1; i >= 0; i-- {
+		in := n.InputLinks[i]
+		if from := in.sender(); in.ID == h.Path && from != nil && from.NodeID == h.Source {
+			in.innovative++
+			in.UsefulBytes.Add(uint64(d.SymbolSize()))
+			if window {
+				break
+			}
+			if in.received == nil {
+				in.received = make(map[uint32]uint32)
+			}
+			in.received[h.Generation]++
+			break
+		}
+	}
+	return true
+}
+
+// outputAttachedDone is called when the output out gets attached to a node
+// that is already done. The receiver tells it as soon as the link is set up,
+// since it may no longer read the link to answer the payloads.
+func (n *Node) outputAttachedDone(out *Link) {
+	n.mu.Lock()
+	out.fb.done = true
+	n.mu.Unlock()
+	n.checkDone()
+}
+
+// outputDone reports whether the node at the other end of the output out is
+// done, as far as the node knows. n.mu must be held.
+func (n *Node) outputDone(out *Link) bool {
+	if out.Feedback != nil {
+		return out.fb.done
+	}
+	to := out.receiver()
+	return to != nil && to.isDone()
+}
+
+// completed reports whether every decoder fed by the node is complete, or the
+// node is a complete decoder, whatever the feedback has told the nodes so far
+func (n *Node) completed() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.OutputLinks) == 0 {
+		return n.isDone()
+	}
+	for _, out := range n.OutputLinks {
+		if to := out.receiver(); to == nil || !to.completed() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package mpthSim
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/JuanCabre/mpthSim/rlnc"
+)
+
+func TestFeedbackRoundTrip(t *testing.T) {
+	reports := map[string]feedback{
+		"empty": {gens: map[uint32]bool{}, ranks: map[uint32]uint32{}},
+		"full": {
+			done:       true,
+			below:      3,
+			gens:       map[uint32]bool{5: true, 4: true, 9: true},
+			delivered:  1 << 20,
+			ranks:      map[uint32]uint32{3: 7, 6: 1, 10: 0xffffffff},
+			innovative: 12,
+		},
+	}
+	for name, f := range reports {
+		got := unmarshalFeedback(f.marshal())
+		if !got.equal(f) {
+			t.Errorf("%s: unmarshaled %+v, want %+v", name, got, f)
+		}
+	}
+}
+
+func TestFeedbackMerge(t *testing.T) {
+	f := feedback{below: 1, gens: map[uint32]bool{3: true}, ranks: map[uint32]uint32{2: 4}}
+	f.merge(feedback{below: 2, gens: map[uint32]bool{2: true}, ranks: map[uint32]uint32{2: 1, 4: 5}})
+	// Generations 2 and 3 are now done, so below skips them and their
+	// ranks are dropped
+	want := feedback{below: 4, ranks: map[uint32]uint32{4: 5}}
+	if !f.equal(want) {
+		t.Errorf("merged %+v, want %+v", f, want)
+	}
+}
+
+// TestFeedbackDoneWithLastGeneration checks that a decoder tells it is done in
+// the report that tells its last generation is. A sender that only learned the
+// latter would stop sending to the decoder, which would then never repeat the
+// report that it is done if it were lost.
+func TestFeedbackDoneWithLastGeneration(t *testing.T) {
+	const symbols, symbolSize = 8, 10
+	enc := NewEncoderNode(NewRLNCEncoderFactory(rlnc.Binary8, symbols, symbolSize), 1000)
+	rand.Read(enc.Data)
+	enc.SetConstSymbols()
+	dec := NewDecoderNode(NewRLNCDecoderFactory(rlnc.Binary8, symbols, symbolSize), 1000)
+	in := NewLink(0, 0)
+	in.Feedback = NewLink(0, 0)
+	dec.AddInput(in)
+
+	decoded := make(chan error, 1)
+	go func() { decoded <- dec.ReceiveCodedPackets(context.Background()) }()
+	for i := 0; i < 10*symbols; i++ {
+		enc.mu.Lock()
+		enc.sendPayload(in)
+		enc.mu.Unlock()
+		in.Out <- <-in.In
+	}
+	<-dec.Done
+	close(in.Out) // The decoder detaches, and stops reporting, once closed
+	if err := <-decoded; err != nil {
+		t.Fatal(err)
+	}
+
+	reports := 0
+	for msg := range in.Feedback.In {
+		f := unmarshalFeedback(msg)
+		if f.generationDone(0) && !f.done {
+			t.Fatalf("report %d: %+v tells the generation is done, not the decoder", reports, f)
+		}
+		reports++
+	}
+	if !dec.isDone() || reports == 0 {
+		t.Errorf("decoder done %v after %d reports, want done", dec.isDone(), reports)
+	}
+}
@@ -0,0 +1,166 @@
+package mpthSim
+
+import "sort"
+
+// SetGenerations splits the transfer of a node into count generations of one
+// block each. It resizes n.Data to count blocks, so it must be called before
+// filling n.Data at the encoder, and before any payload is received at the
+// decoders and the recoders, which drop the payloads of the generations past
+// count.
+func (n *Node) SetGenerations(count uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.generations = count
+	if n.Encoder != nil {
+		n.Data = make([]byte, count*n.Encoder.BlockSize())
+		n.encoders = []Encoder{n.Encoder}
+		for g := uint32(1); g < count; g++ {
+			n.encoders = append(n.encoders, n.encoderFactory.Build())
+		}
+		return
+	}
+	size := n.Decoder.BlockSize()
+	n.Data = make([]byte, count*size)
+	n.decoders = map[uint32]Decoder{0: n.Decoder}
+	for g := uint32(1); g < count; g++ {
+		n.decoders[g] = n.decoderFactory.Build()
+	}
+	for g, d := range n.decoders {
+		d.SetMutableSymbols(n.Data[g*size : (g+1)*size])
+	}
+}
+
+// Generations returns the number of generations of the transfer
+func (n *Node) Generations() uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.generations
+}
+
+// nextCoder chooses the generation of the next payload sent by the node
+// through the link out, going round robin over the generations in flight, and
+// returns its coder. Every link keeps its own position, so that all of them
+// carry all the generations. The encoder sends the first Window generations
+// that are not done yet, and a recoder the ones it holds that are not done
+// yet. The generations of which the receiver already holds everything the node
+// can contribute are skipped, see RankFeedback, and the payload is counted as
+// saved if that leaves none. It returns a nil coder if there is nothing to
+// send. n.mu must be held.
+func (n *Node) nextCoder(out *Link) (uint32, payloadWriter) {
+	var active []uint32
+	if n.Encoder != nil {
+		n.slideWindow()
+		for n.base < n.generations && n.downstreamDone(n.base) {
+			n.base++
+		}
+		end := n.generations
+		if n.Window > 0 && n.base+n.Window < end {
+			end = n.base + n.Window
+		}
+		for g := n.base; g < end; g++ {
+			if !n.downstreamDone(g) {
+				active = append(active, g)
+			}
+		}
+	} else {
+		for g, d := range n.decoders {
+			if n.downstreamDone(g) {
+				n.deleteDecoder(g)
+			} else if d.Rank() > 0 {
+				active = append(active, g)
+			}
+		}
+		sort.Slice(active, func(i, j int) bool { return active[i] < active[j] })
+	}
+	if len(active) == 0 {
+		return 0, nil
+	}
+	var needed []uint32
+	for _, g := range active {
+		if n.outputRank(out, g) < n.coder(g).Rank() {
+			needed = append(needed, g)
+		}
+	}
+	if len(needed) == 0 {
+		n.SavedTransmissions.Add(1)
+		return 0, nil
+	}
+	g := needed[out.next%len(needed)]
+	out.next++
+	return g, n.coder(g)
+}
+
+// coder returns the encoder of the generation g, or its recoder. n.mu must be
+// held.
+func (n *Node) coder(g uint32) payloadWriter {
+	if n.Encoder != nil {
+		return n.encoders[g]
+	}
+	return n.decoders[g]
+}
+
+// decoder returns the decoder of the generation g of a recoder, which is
+// created on the first payload of the generation. n.mu must be held.
+func (n *Node) decoder(g uint32) Decoder {
+	d, ok := n.decoders[g]
+	if !ok {
+		d = n.decoderFactory.Build()
+		d.SetMutableSymbols(make([]byte, d.BlockSize()))
+		n.decoders[g] = d
+	}
+	return d
+}
+
+// deleteDecoder frees the decoder of a generation that is no longer needed.
+// n.mu must be held.
+func (n *Node) deleteDecoder(g uint32) {
+	d := n.decoders[g]
+	delete(n.decoders, g)
+	if del, ok := d.(deleter); ok && d != n.Decoder {
+		del.Delete()
+	}
+}
+
+// generationDone reports whether the generation g is no longer needed by the
+// node, i.e., it is decoded at a decoder, or done at every node a recoder or
+// the encoder sends to, as reported by the feedback of the links that have one
+func (n *Node) generationDone(g uint32) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.downstreamDone(g)
+}
+
+// downstreamDone is generationDone with n.mu held. Generations never become
+// needed again, so the answer is cached once it is true.
+func (n *Node) downstreamDone(g uint32) bool {
+	if n.doneGens[g] {
+		return true
+	}
+	if len(n.OutputLinks) == 0 {
+		return false
+	}
+	for _, out := range n.OutputLinks {
+		if out.Feedback != nil {
+			if !out.fb.generationDone(g) {
+				return false
+			}
+		} else if to := out.receiver(); to == nil || !to.generationDone(g) {
+			return false
+		}
+	}
+	n.doneGens[g] = true
+	return true
+}
+
+// deliver hands the generations decoded at a decoder to OnDeliver in order,
+// as long as there is no gap. n.mu must be held.
+func (n *Node) deliver() {
+	size := n.Decoder.BlockSize()
+	for n.Delivered < n.generations && n.doneGens[n.Delivered] {
+		if n.OnDeliver != nil {
+			g := n.Delivered
+			n.OnDeliver(g, n.Data[g*size:(g+1)*size])
+		}
+		n.Delivered++
+	}
+}
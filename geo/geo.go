@@ -0,0 +1,124 @@
+// Package geo provides the geometry needed to compute the propagation delay
+// between ground stations, aircraft, HAPS and satellites. Positions are given
+// in the Earth-centered, Earth-fixed (ECEF) frame in meters.
+package geo
+
+import (
+	"math"
+	"time"
+)
+
+// Constants of the WGS84 ellipsoid and of the Earth
+const (
+	EarthRadius     = 6378137.0 // Equatorial radius in m
+	EarthFlattening = 1 / 298.257223563
+	EarthRotation   = 7.2921150e-5   // Rotation rate in rad/s
+	EarthMu         = 3.986004418e14 // Gravitational parameter in m^3/s^2
+	SpeedOfLight    = 299792458.0    // m/s
+)
+
+// Vec3 is a position or a velocity in Cartesian coordinates
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// Add returns v+w
+func (v Vec3) Add(w Vec3) Vec3 { return Vec3{v.X + w.X, v.Y + w.Y, v.Z + w.Z} }
+
+// Sub returns v-w
+func (v Vec3) Sub(w Vec3) Vec3 { return Vec3{v.X - w.X, v.Y - w.Y, v.Z - w.Z} }
+
+// Scale returns k*v
+func (v Vec3) Scale(k float64) Vec3 { return Vec3{k * v.X, k * v.Y, k * v.Z} }
+
+// Dot returns the dot product of v and w
+func (v Vec3) Dot(w Vec3) float64 { return v.X*w.X + v.Y*w.Y + v.Z*w.Z }
+
+// Norm returns the length of v
+func (v Vec3) Norm() float64 { return math.Sqrt(v.Dot(v)) }
+
+// Positioner is anything whose ECEF position is known at every time
+type Positioner interface {
+	Position(t time.Time) Vec3
+}
+
+// LLA is a fixed position given by its geodetic latitude and longitude in
+// degrees and its altitude over the WGS84 ellipsoid in meters. It is used for
+// ground stations and for HAPS.
+type LLA struct {
+	Lat, Lon, Alt float64
+}
+
+// ECEF converts the geodetic coordinates to ECEF
+func (p LLA) ECEF() Vec3 {
+	lat := p.Lat * math.Pi / 180
+	lon := p.Lon * math.Pi / 180
+	e2 := EarthFlattening * (2 - EarthFlattening)
+	n := EarthRadius / math.Sqrt(1-e2*math.Sin(lat)*math.Sin(lat))
+	return Vec3{
+		X: (n + p.Alt) * math.Cos(lat) * math.Cos(lon),
+		Y: (n + p.Alt) * math.Cos(lat) * math.Sin(lon),
+		Z: (n*(1-e2) + p.Alt) * math.Sin(lat),
+	}
+}
+
+// Position returns the ECEF position of p, which does not move
+func (p LLA) Position(t time.Time) Vec3 { return p.ECEF() }
+
+// CircularOrbit is a satellite on a circular Keplerian orbit. The angles are
+// in degrees: the inclination, the right ascension of the ascending node
+// (RAAN) and the argument of latitude of the satellite at Epoch (Phase). The
+// inertial frame is taken to be aligned with ECEF at Epoch, so the RAAN is
+// the longitude of the ascending node at Epoch.
+type CircularOrbit struct {
+	Altitude    float64 // Over the equatorial radius in m
+	Inclination float64
+	RAAN        float64
+	Phase       float64
+	Epoch       time.Time
+}
+
+// Period returns the orbital period
+func (o CircularOrbit) Period() time.Duration {
+	return time.Duration(2 * math.Pi / o.meanMotion() * float64(time.Second))
+}
+
+func (o CircularOrbit) meanMotion() float64 {
+	a := EarthRadius + o.Altitude
+	return math.Sqrt(EarthMu / (a * a * a))
+}
+
+// Position returns the ECEF position of the satellite at time t
+func (o CircularOrbit) Position(t time.Time) Vec3 {
+	dt := t.Sub(o.Epoch).Seconds()
+	a := EarthRadius + o.Altitude
+	u := o.Phase*math.Pi/180 + o.meanMotion()*dt
+	inc := o.Inclination * math.Pi / 180
+	raan := o.RAAN * math.Pi / 180
+
+	// Position in the inertial frame
+	eci := Vec3{
+		X: a * (math.Cos(raan)*math.Cos(u) - math.Sin(raan)*math.Sin(u)*math.Cos(inc)),
+		Y: a * (math.Sin(raan)*math.Cos(u) + math.Cos(raan)*math.Sin(u)*math.Cos(inc)),
+		Z: a * math.Sin(u) * math.Sin(inc),
+	}
+	return RotateZ(eci, -EarthRotation*dt)
+}
+
+// RotateZ rotates v by the angle theta in radians around the Z axis
+func RotateZ(v Vec3, theta float64) Vec3 {
+	c, s := math.Cos(theta), math.Sin(theta)
+	return Vec3{X: c*v.X - s*v.Y, Y: s*v.X + c*v.Y, Z: v.Z}
+}
+
+// Distance returns the distance in meters between a and b at time t
+func Distance(a, b Positioner, t time.Time) float64 {
+	return a.Position(t).Sub(b.Position(t)).Norm()
+}
+
+// PropagationDelay returns the time light takes to travel between a and b at
+// time t. The motion of the endpoints during the propagation is neglected,
+// which is an error well below a microsecond even for LEO satellites.
+func PropagationDelay(a, b Positioner, t time.Time) time.Duration {
+	return time.Duration(Distance(a, b, t) / SpeedOfLight * float64(time.Second))
+}
@@ -0,0 +1,131 @@
+package geo
+
+import (
+	"math"
+	"time"
+)
+
+// SurfaceAltitude is the altitude under which a position is considered to be
+// on the surface of the Earth, like ground stations, aircraft and HAPS, and
+// hence subject to an elevation mask
+const SurfaceAltitude = 100e3
+
+// ToLLA converts an ECEF position to geodetic coordinates
+func ToLLA(v Vec3) LLA {
+	e2 := EarthFlattening * (2 - EarthFlattening)
+	p := math.Hypot(v.X, v.Y)
+	lon := math.Atan2(v.Y, v.X)
+	lat := math.Atan2(v.Z, p*(1-e2))
+	var alt float64
+	for i := 0; i < 5; i++ {
+		sin := math.Sin(lat)
+		n := EarthRadius / math.Sqrt(1-e2*sin*sin)
+		alt = p/math.Cos(lat) - n
+		lat = math.Atan2(v.Z, p*(1-e2*n/(n+alt)))
+	}
+	return LLA{Lat: lat * 180 / math.Pi, Lon: lon * 180 / math.Pi, Alt: alt}
+}
+
+// Elevation returns the angle in degrees of target over the local horizon of
+// observer
+func Elevation(observer, target Vec3) float64 {
+	lla := ToLLA(observer)
+	lat := lla.Lat * math.Pi / 180
+	lon := lla.Lon * math.Pi / 180
+	up := Vec3{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+	d := target.Sub(observer)
+	return math.Asin(d.Dot(up)/d.Norm()) * 180 / math.Pi
+}
+
+// LineOfSight reports whether the segment between a and b stays above the
+// given altitude over a spherical Earth of radius EarthRadius
+func LineOfSight(a, b Vec3, altitude float64) bool {
+	d := b.Sub(a)
+	// Point of the segment closest to the center of the Earth
+	k := 0.0
+	if dd := d.Dot(d); dd > 0 {
+		k = math.Max(0, math.Min(1, -a.Dot(d)/dd))
+	}
+	return a.Add(d.Scale(k)).Norm() >= EarthRadius+altitude
+}
+
+// Visibility decides when two endpoints can communicate. Endpoints on the
+// surface of the Earth see the other endpoint only above MinElevation degrees,
+// and endpoints in space only while the line between them clears the Earth
+// and its atmosphere up to MinAltitude meters.
+type Visibility struct {
+	From, To     Positioner
+	MinElevation float64
+	MinAltitude  float64
+}
+
+// Visible reports whether the endpoints see each other at time t
+func (v Visibility) Visible(t time.Time) bool {
+	a, b := v.From.Position(t), v.To.Position(t)
+	if ToLLA(a).Alt < SurfaceAltitude && Elevation(a, b) < v.MinElevation {
+		return false
+	}
+	if ToLLA(b).Alt < SurfaceAltitude && Elevation(b, a) < v.MinElevation {
+		return false
+	}
+	if ToLLA(a).Alt >= SurfaceAltitude && ToLLA(b).Alt >= SurfaceAltitude {
+		return LineOfSight(a, b, v.MinAltitude)
+	}
+	return true
+}
+
+// Window is an interval of time during which two endpoints see each other
+type Window struct {
+	Start, End time.Time
+}
+
+// Windows returns the visibility windows between from and to. The visibility
+// is sampled every step, which must be shorter than the shortest window or
+// gap of interest, and the edges of the windows are refined to a millisecond.
+// Windows open at from or still open at to are cut at those times. It panics
+// if step is not positive.
+func (v Visibility) Windows(from, to time.Time, step time.Duration) []Window {
+	if step <= 0 {
+		panic("geo: non-positive visibility step")
+	}
+	var windows []Window
+	visible := v.Visible(from)
+	var open time.Time
+	if visible {
+		open = from
+	}
+	for t := from; t.Before(to); {
+		next := t.Add(step)
+		if next.After(to) {
+			next = to
+		}
+		if v.Visible(next) != visible {
+			edge := v.edge(t, next, visible)
+			if visible {
+				windows = append(windows, Window{Start: open, End: edge})
+			} else {
+				open = edge
+			}
+			visible = !visible
+		}
+		t = next
+	}
+	if visible {
+		windows = append(windows, Window{Start: open, End: to})
+	}
+	return windows
+}
+
+// edge finds by bisection the time between a and b at which the visibility
+// changes from before
+func (v Visibility) edge(a, b time.Time, before bool) time.Time {
+	for b.Sub(a) > time.Millisecond {
+		mid := a.Add(b.Sub(a) / 2)
+		if v.Visible(mid) == before {
+			a = mid
+		} else {
+			b = mid
+		}
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package mpthSim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HeaderVersion is the version of the packet header written by the nodes
+const HeaderVersion = 1
+
+// HeaderSize is the size of the packet header that precedes the coded payload
+// of every packet: the version, the hop count, the source, path, generation
+// and sequence number, and the send timestamp, in big-endian order
+const HeaderSize = 1 + 1 + 4 + 4 + 4 + 4 + 8
+
+// Errors returned by ParseHeader
+var (
+	ErrShortPacket = errors.New("mpthSim: packet shorter than its header")
+	ErrEmptyPacket = errors.New("mpthSim: packet without a coded payload")
+)
+
+// Header is the header of a packet sent by a node
+type Header struct {
+	Version uint8
+	// Source is the NodeID of the node that coded the packet, and Path the
+	// ID of the link it sent it through
+	Source uint32
+	Path   uint32
+	// Generation of the coded payload, and number of payloads the source sent
+	// through the path before it
+	Generation uint32
+	Seq        uint32
+	// Sent is the time at which the source sent the packet
+	Sent time.Time
+	// Hops is the number of links crossed by the coded data so far, counting
+	// the one the packet is sent through
+	Hops uint8
+}
+
+// Marshal writes the header at the beginning of packet, which must hold at
+// least HeaderSize bytes
+func (h *Header) Marshal(packet []byte) {
+	packet[0] = h.Version
+	packet[1] = h.Hops
+	binary.BigEndian.PutUint32(packet[2:], h.Source)
+	binary.BigEndian.PutUint32(packet[6:], h.Path)
+	binary.BigEndian.PutUint32(packet[10:], h.Generation)
+	binary.BigEndian.PutUint32(packet[14:], h.Seq)
+	binary.BigEndian.PutUint64(packet[18:], uint64(h.Sent.UnixNano()))
+}
+
+// ParseHeader reads the header written by Marshal at the beginning of packet
+// and returns it with the coded payload that follows. It returns an error if
+// the packet is too short or of another version.
+func ParseHeader(packet []byte) (Header, []byte, error) {
+	var h Header
+	if len(packet) < HeaderSize {
+		return h, nil, ErrShortPacket
+	}
+	if packet[0] != HeaderVersion {
+		return h, nil, fmt.Errorf("mpthSim: unsupported header version %d", packet[0])
+	}
+	if len(packet) == HeaderSize {
+		return h, nil, ErrEmptyPacket
+	}
+	h.Version = packet[0]
+	h.Hops = packet[1]
+	h.Source = binary.BigEndian.Uint32(packet[2:])
+	h.Path = binary.BigEndian.Uint32(packet[6:])
+	h.Generation = binary.BigEndian.Uint32(packet[10:])
+	h.Seq = binary.BigEndian.Uint32(packet[14:])
+	h.Sent = time.Unix(0, int64(binary.BigEndian.Uint64(packet[18:])))
+	return h, packet[HeaderSize:], nil
+}
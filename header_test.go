@@ -0,0 +1,49 @@
+package mpthSim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := Header{
+		Version:    HeaderVersion,
+		Source:     0xdeadbeef,
+		Path:       7,
+		Generation: 1 << 31,
+		Seq:        42,
+		Sent:       time.Unix(1234, 567890),
+		Hops:       3,
+	}
+	packet := make([]byte, HeaderSize+5)
+	h.Marshal(packet)
+	copy(packet[HeaderSize:], "coded")
+
+	got, payload, err := ParseHeader(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != h {
+		t.Errorf("parsed %+v, want %+v", got, h)
+	}
+	if string(payload) != "coded" {
+		t.Errorf("payload %q, want %q", payload, "coded")
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	h := Header{Version: HeaderVersion}
+	packet := make([]byte, HeaderSize+1)
+	h.Marshal(packet)
+
+	if _, _, err := ParseHeader(packet[:HeaderSize-1]); err != ErrShortPacket {
+		t.Errorf("short packet: got %v, want %v", err, ErrShortPacket)
+	}
+	if _, _, err := ParseHeader(packet[:HeaderSize]); err != ErrEmptyPacket {
+		t.Errorf("header only: got %v, want %v", err, ErrEmptyPacket)
+	}
+	packet[0] = HeaderVersion + 1
+	if _, _, err := ParseHeader(packet); err == nil {
+		t.Error("other version: no error")
+	}
+}
@@ -0,0 +1,92 @@
+package mpthSim
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Jitter draws the variable part of the delay of each packet, which is added
+// to the delay given by the DelayModel of a Link
+type Jitter interface {
+	// Sample returns the extra delay of the next packet
+	Sample() time.Duration
+}
+
+// newRand returns a generator of its own for a loss model or a jitter, seeded
+// from the global one so that the seed of a simulation reproduces it
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// UniformJitter draws the extra delay uniformly in [Min, Max)
+type UniformJitter struct {
+	Min, Max time.Duration
+	rng      *rand.Rand
+}
+
+// NewUniformJitter creates a jitter uniformly distributed in [min, max)
+func NewUniformJitter(min, max time.Duration) *UniformJitter {
+	return &UniformJitter{Min: min, Max: max, rng: newRand()}
+}
+
+// Sample draws a uniform extra delay
+func (j *UniformJitter) Sample() time.Duration {
+	return j.Min + time.Duration(j.rng.Float64()*float64(j.Max-j.Min))
+}
+
+// NormalJitter draws the extra delay from a normal distribution. Negative
+// samples shorten the delay of the packet, down to zero.
+type NormalJitter struct {
+	Mean, StdDev time.Duration
+	rng          *rand.Rand
+}
+
+// NewNormalJitter creates a normally distributed jitter
+func NewNormalJitter(mean, stdDev time.Duration) *NormalJitter {
+	return &NormalJitter{Mean: mean, StdDev: stdDev, rng: newRand()}
+}
+
+// Sample draws a normal extra delay
+func (j *NormalJitter) Sample() time.Duration {
+	return j.Mean + time.Duration(j.rng.NormFloat64()*float64(j.StdDev))
+}
+
+// ParetoJitter draws the extra delay from a heavy-tailed Pareto distribution
+// with the given scale (the minimum extra delay) and shape. The smaller the
+// shape, the heavier the tail.
+type ParetoJitter struct {
+	Scale time.Duration
+	Shape float64
+	rng   *rand.Rand
+}
+
+// NewParetoJitter creates a Pareto distributed jitter
+func NewParetoJitter(scale time.Duration, shape float64) *ParetoJitter {
+	return &ParetoJitter{Scale: scale, Shape: shape, rng: newRand()}
+}
+
+// Sample draws a Pareto extra delay by inverse transform sampling
+func (j *ParetoJitter) Sample() time.Duration {
+	u := 1 - j.rng.Float64() // (0, 1]
+	return time.Duration(float64(j.Scale) / math.Pow(u, 1/j.Shape))
+}
+
+// EmpiricalJitter draws the extra delay uniformly among measured samples
+type EmpiricalJitter struct {
+	Samples []time.Duration
+	rng     *rand.Rand
+}
+
+// NewEmpiricalJitter creates a jitter that resamples the given measurements
+func NewEmpiricalJitter(samples []time.Duration) *EmpiricalJitter {
+	return &EmpiricalJitter{Samples: samples, rng: newRand()}
+}
+
+// Sample returns one of the measured samples
+func (j *EmpiricalJitter) Sample() time.Duration {
+	if len(j.Samples) == 0 {
+		return 0
+	}
+	return j.Samples[j.rng.Intn(len(j.Samples))]
+}
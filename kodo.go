@@ -0,0 +1,87 @@
+//go:build kodo
+// +build kodo
+
+package mpthSim
+
+import (
+	"gitlab.com/steinwurf/kodo-go/src/kodo"
+)
+
+// The kodo backend needs the kodo-go bindings and their C++ library, so it is
+// only built with the kodo build tag, e.g., go build -tags kodo
+
+// KodoEncoderFactory builds encoders with a kodo encoder factory
+type KodoEncoderFactory struct {
+	*kodo.EncoderFactory
+}
+
+// NewKodoEncoderFactory creates a kodo encoder factory with the given code,
+// field and block parameters. Delete must be called once it is not needed.
+func NewKodoEncoderFactory(code kodo.CodeType, field kodo.FiniteField, symbols, symbolSize uint32) KodoEncoderFactory {
+	return KodoEncoderFactory{kodo.NewEncoderFactory(code, field, symbols, symbolSize)}
+}
+
+// Build creates a new kodo encoder
+func (f KodoEncoderFactory) Build() Encoder {
+	return kodoEncoder{f.EncoderFactory.Build()}
+}
+
+// Delete frees the memory of the factory
+func (f KodoEncoderFactory) Delete() {
+	kodo.DeleteEncoderFactory(f.EncoderFactory)
+}
+
+// KodoDecoderFactory builds decoders with a kodo decoder factory
+type KodoDecoderFactory struct {
+	*kodo.DecoderFactory
+}
+
+// NewKodoDecoderFactory creates a kodo decoder factory with the given code,
+// field and block parameters. Delete must be called once it is not needed.
+func NewKodoDecoderFactory(code kodo.CodeType, field kodo.FiniteField, symbols, symbolSize uint32) KodoDecoderFactory {
+	return KodoDecoderFactory{kodo.NewDecoderFactory(code, field, symbols, symbolSize)}
+}
+
+// Build creates a new kodo decoder
+func (f KodoDecoderFactory) Build() Decoder {
+	return kodoDecoder{f.DecoderFactory.Build()}
+}
+
+// Delete frees the memory of the factory
+func (f KodoDecoderFactory) Delete() {
+	kodo.DeleteDecoderFactory(f.DecoderFactory)
+}
+
+// kodoEncoder adapts a kodo encoder to the Encoder interface
+type kodoEncoder struct {
+	*kodo.Encoder
+}
+
+func (e kodoEncoder) WritePayload(payload []byte) uint32 {
+	return e.Encoder.WritePayload(&payload[0])
+}
+
+func (e kodoEncoder) SetConstSymbols(data []byte) {
+	e.Encoder.SetConstSymbols(&data[0], e.BlockSize())
+}
+
+func (e kodoEncoder) Delete() { kodo.DeleteEncoder(e.Encoder) }
+
+// kodoDecoder adapts a kodo decoder to the Decoder interface
+type kodoDecoder struct {
+	*kodo.Decoder
+}
+
+func (d kodoDecoder) WritePayload(payload []byte) uint32 {
+	return d.Decoder.WritePayload(&payload[0])
+}
+
+func (d kodoDecoder) ReadPayload(payload []byte) {
+	d.Decoder.ReadPayload(&payload[0])
+}
+
+func (d kodoDecoder) SetMutableSymbols(data []byte) {
+	d.Decoder.SetMutableSymbols(&data[0], d.BlockSize())
+}
+
+func (d kodoDecoder) Delete() { kodo.DeleteDecoder(d.Decoder) }
@@ -1,7 +1,7 @@
 package mpthSim
 
 import (
-	"math/rand"
+	"context"
 	"sync"
 	"time"
 
@@ -10,67 +10,370 @@ import (
 
 var debugL = dbg.Debug("Link")
 
-// Link represents a communication channel with a loss probability and a delay
+// Link represents a communication channel with a loss model and a delay
 type Link struct {
-	In       chan []byte
-	Out      chan []byte
-	lossProb float64
-	delay    time.Duration
+	// ID of the link, the Path of the packets sent through it, see Header
+	ID    uint32
+	In    chan []byte
+	Out   chan []byte
+	loss  LossModel
+	delay DelayModel
 
+	// Clock is the time source used to delay the packets. It must be the same
+	// clock used by the nodes attached to the link, and it must be set before
+	// calling ProcessPackets.
+	Clock Clock
+
+	// DestGone is set to nil, under mu, once the receiver is reset, so that
+	// the sender closes the link
 	DestGone chan struct{}
 
-	InCount, OutCount, LostCount uint64
+	// Nodes at both ends of the link, set by AddOutput and AddInput
+	from, to *Node
+
+	// State of the sender, guarded by it: its round robin position over its
+	// generations, the payloads its Scheduler owes to the link, and its rate
+	// through the link in B/s and the time of its next payload, see pace
+	next     int
+	credit   float64
+	rate     uint64
+	nextSend time.Time
+
+	// Transmissions counts the payloads the sender sent through the link
+	Transmissions Counter
+
+	// Feedback, if set, carries the reports of the receiver back to the
+	// sender, see feedback.go. It must be set before AddOutput and AddInput.
+	// fb is the feedback received so far, guarded by the sender, and
+	// feedbackClosed is set by the receiver once it closes Feedback.In.
+	Feedback       *Link
+	fb             feedback
+	feedbackClosed bool
+	// received counts the innovative payloads of each generation the receiver
+	// got through the link, innovative all of them, and reported is the last
+	// report it sent through Feedback. They are guarded by the receiver.
+	received   map[uint32]uint32
+	innovative uint32
+	reported   feedback
+
+	// Capacity of the link in bits/s and maximum number of packets waiting
+	// for the transmitter. Zero means unlimited.
+	capacity float64
+	queueLen int
+	queue    linkQueue
+
+	// Jitter added to the delay of each packet. Unless reorder is set, a
+	// packet is never delivered before the ones that entered the link earlier:
+	// it is delayed until lastDelivery at least, and since the timers of a
+	// RealClock may fire out of order, it also waits for lastSent, which is
+	// closed once the previous packet is delivered or dropped.
+	jitter       Jitter
+	reorder      bool
+	lastDelivery time.Time
+	lastSent     chan struct{}
+
+	// owner sets the timers that deliver the packets, see Owner
+	owner *Owner
+
+	// mu guards the state of the path while packets flow, so the sender can
+	// estimate it, see PathState, and the queue, so it can be sampled
+	mu        sync.Mutex
+	maxSeq    uint64        // Highest sequence number delivered
+	lastDelay time.Duration // Delay of the last packet not lost, with its queueing
+	inFlight  int           // Payloads waiting for their delivery
+	closing   bool          // The Input channel is closed
+	outClosed bool          // The Output channel is closed, see closeOut
+
+	// The packets that entered the link, and the ones delivered, lost,
+	// dropped by the queue and delivered after a later one. They can be read
+	// at any time, see Stats.
+	InCount, OutCount, LostCount, DroppedCount, ReorderedCount Counter
+
+	// InBytes counts the bytes of the packets sent through the link, headers
+	// included, and UsefulBytes the bytes of the symbols they carried that
+	// were innovative at the receiver
+	InBytes, UsefulBytes Counter
 }
 
-// NewLink creates a new link with the given loss probability and delay
+// NewLink creates a new link with the given loss probability and delay.
+// Packets are lost independently of each other.
 func NewLink(lossProb float64, delay time.Duration) *Link {
+	return NewLinkWithLoss(NewBernoulli(lossProb), delay)
+}
+
+// NewLinkWithLoss creates a new link with the given loss model and delay
+func NewLinkWithLoss(loss LossModel, delay time.Duration) *Link {
+	return NewLinkWithModels(loss, ConstDelay(delay))
+}
+
+// NewLinkWithModels creates a new link whose losses and delays are given by
+// the models
+func NewLinkWithModels(loss LossModel, delay DelayModel) *Link {
 	l := new(Link)
 	l.In = make(chan []byte, 10000)
 	l.Out = make(chan []byte, 10000)
 	l.DestGone = make(chan struct{})
 
 	l.delay = delay
-	l.lossProb = lossProb
+	l.loss = loss
+	l.Clock = RealClock{}
+	l.owner = NewOwner()
 
 	return l
 }
 
+// SetCapacity limits the link to capacity bits/s, so every packet spends the
+// time needed to serialize its payload before it starts to propagate. Packets
+// arriving while the transmitter is busy wait in a FIFO queue of at most
+// queueLen packets, and the ones that find it full are dropped (drop-tail).
+// A capacity or queueLen of zero means unlimited. It must be called before
+// ProcessPackets.
+func (l *Link) SetCapacity(capacity float64, queueLen int) {
+	l.capacity = capacity
+	l.queueLen = queueLen
+}
+
+// SetJitter adds a random extra delay drawn from j to every packet. If reorder
+// is false, packets overtaken by earlier ones are held back so that the link
+// stays FIFO; otherwise they are delivered as soon as their delay elapses and
+// counted in ReorderedCount. It must be called before ProcessPackets.
+func (l *Link) SetJitter(j Jitter, reorder bool) {
+	l.jitter = j
+	l.reorder = reorder
+}
+
 // ProcessPackets listens the Input channel of the link until it is close and
-// sends the incoming payload to a go routine DelayAndSend
-func (l *Link) ProcessPackets() {
+// schedules the delivery of the incoming payloads with delayAndSend. Every
+// payload sent
+// to the Input channel must have been accounted with l.Clock.Hold; the link
+// releases it once processed and holds it again when it is delivered to the
+// Output channel. The same goes for the closing of the Input channel, see
+// Close. Once ctx is cancelled, the link drops the payloads instead, until the
+// sender closes the Input channel. It returns once every payload is delivered
+// or dropped and the Output channel is closed, with ctx.Err() if any was
+// dropped that way.
+func (l *Link) ProcessPackets(ctx context.Context) error {
 
 	// WaitGroup to close the output channel of the Link after all packets have
 	// been sent
 	var wg sync.WaitGroup
 
 	for payload := range l.In {
+		payload := payload // Captured by the delivery below
+		if ctx.Err() != nil {
+			l.Clock.Release() // Dropped
+			continue
+		}
 		debugL("received Packet")
-		l.InCount++ // Increase by one the received packets
+		l.InBytes.Add(uint64(len(payload)))
+		seq := l.InCount.Add(1) // Increase by one the received packets
 		// debugL("Received packet: %v", payload)
 
+		now := l.Clock.Now()
+		// Queue the packet for transmission, unless the queue is full
+		l.mu.Lock()
+		l.queue.advance(now)
+		if l.queueLen > 0 && l.queue.waiting() >= l.queueLen {
+			l.mu.Unlock()
+			l.DroppedCount.Add(1)
+			debugL("Queue full, packet dropped")
+			l.Clock.Release()
+			continue
+		}
+		sent := now
+		if l.capacity > 0 {
+			sent = l.queue.push(now, time.Duration(float64(8*len(payload))/l.capacity*float64(time.Second)))
+		}
+		l.mu.Unlock()
+
 		// If there are no losses, send the packet
-		if rand.Float64() > l.lossProb {
+		if !l.loss.Lost(sent) {
+			delay := sent.Sub(now) + l.delay.Delay(sent)
+			if l.jitter != nil {
+				delay += l.jitter.Sample()
+			}
+			if delay < 0 {
+				delay = 0 // A negative jitter shortens the delay down to zero
+			}
+			l.mu.Lock()
+			l.lastDelay = delay
+			l.inFlight++
+			l.mu.Unlock()
+			delivery := now.Add(delay)
+			if !l.reorder && delivery.Before(l.lastDelivery) {
+				delivery = l.lastDelivery // Wait for the earlier packets
+			}
+			if delivery.After(l.lastDelivery) {
+				l.lastDelivery = delivery
+			}
+
+			var after, sent chan struct{}
+			if !l.reorder {
+				after, sent = l.lastSent, make(chan struct{})
+				l.lastSent = sent
+			}
+
 			wg.Add(1)
-			go l.delayAndSend(payload, &wg) // Delay and send the packet
+			// Delay and send the packet
+			l.Clock.AfterFunc(l.owner, delivery.Sub(now), func() { l.delayAndSend(ctx, payload, seq, after, sent, &wg) })
 		} else {
-			l.LostCount++
+			l.LostCount.Add(1)
 			debugL("A loss occured")
 		}
+		l.Clock.Release()
 	}
 
 	// If the Input channel was closed, then we close the out channel after
-	// sending all
+	// sending all: now if nothing is in flight, otherwise once the last
+	// payload is delivered or dropped, see delivered
+	l.mu.Lock()
+	l.closing = true
+	last := l.inFlight == 0
+	l.mu.Unlock()
+	if last {
+		l.closeOut() // Hands over the hold of the sender
+	} else {
+		l.Clock.Release()
+	}
 	wg.Wait()
+	return ctx.Err()
+}
+
+// Close closes the Input channel of the link, once the sender sends nothing
+// more through it. Like the payloads, the closing is accounted in l.Clock: the
+// link holds it until the receiver handles the closing of the Output channel,
+// so that it happens at the same virtual time on every run.
+func (l *Link) Close() {
+	l.Clock.Hold() // The link releases it
+	close(l.In)
+}
+
+// closeOut closes the Output channel, with l.Clock held for the receiver,
+// which releases it once it has handled the closing. If no node receives from
+// the link yet, it is released at once, and setTo holds it again.
+func (l *Link) closeOut() {
 	debugL("Closing link Channel")
+	l.mu.Lock()
+	l.outClosed = true
+	to := l.to
+	l.mu.Unlock()
 	close(l.Out)
+	if to == nil {
+		l.Clock.Release()
+	}
+}
+
+// delivered accounts for a payload delivered or dropped by delayAndSend. The
+// last one in flight through a closed link closes the Output channel.
+func (l *Link) delivered() {
+	l.mu.Lock()
+	l.inFlight--
+	last := l.closing && l.inFlight == 0
+	l.mu.Unlock()
+	if last {
+		l.Clock.Hold() // For the receiver, see closeOut
+		l.closeOut()
+	}
+}
+
+// Goodput returns the ratio of the bytes sent through the link that were
+// useful to the receiver, see UsefulBytes, or zero if nothing was sent
+func (l *Link) Goodput() float64 {
+	return l.Stats().Goodput()
+}
+
+// QueueStats returns the occupancy statistics of the transmission queue of the
+// link. It is only meaningful for links with a limited capacity.
+func (l *Link) QueueStats() QueueStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queue.stats()
+}
+
+// setFrom attaches the sending node. If the receiving node is already done,
+// the sender may be done as well.
+func (l *Link) setFrom(n *Node) {
+	l.mu.Lock()
+	l.from = n
+	to := l.to
+	l.mu.Unlock()
+	if to != nil && to.isDone() {
+		n.outputAttachedDone(l)
+	}
+}
+
+// setTo attaches the receiving node. If it is already done, the sender may be
+// done as well. If the Output channel is already closed, it holds l.Clock for
+// the node, see closeOut.
+func (l *Link) setTo(n *Node) {
+	l.mu.Lock()
+	l.to = n
+	from, closed := l.from, l.outClosed
+	l.mu.Unlock()
+	if closed {
+		l.Clock.Hold()
+	}
+	if from != nil && n.isDone() {
+		from.outputAttachedDone(l)
+	}
+}
+
+// sender returns the node that sends through the link, if any
+func (l *Link) sender() *Node {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.from
 }
 
-// DelayAndSend receives a payload and waits for l.delay before sending it to
-// the output channel of the link
-func (l *Link) delayAndSend(payload []byte, wg *sync.WaitGroup) {
-	<-time.After(l.delay) // Delay the packet
-	l.Out <- payload      // Send packet to the output channel
-	l.OutCount++          // Increase by one the received packets
+// receiver returns the node that receives from the link, if any
+func (l *Link) receiver() *Node {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.to
+}
+
+// DelayAndSend is called once the delay of a payload has elapsed and sends it
+// to the output channel of the link. seq is the order in which the payload
+// entered the link. Unless they are nil, it waits for after to be closed
+// before sending, and closes sent once done, so the payloads kept in order
+// are delivered in order even if their timers fire out of order. On a
+// VirtualClock, after is always closed by then. The payload is held in
+// l.Clock again, so the receiver of l.Out is responsible for releasing it. It
+// drops the payload if ctx is cancelled, since the receiver may no longer read
+// it.
+func (l *Link) delayAndSend(ctx context.Context, payload []byte, seq uint64, after <-chan struct{}, sent chan<- struct{}, wg *sync.WaitGroup) {
+	defer wg.Done() // Update the information of the waitgroup
+	defer l.delivered()
+	if sent != nil {
+		defer close(sent)
+	}
+	if after != nil {
+		select {
+		case <-after:
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	l.Clock.Hold()
+	select {
+	case l.Out <- payload: // Send packet to the output channel
+	case <-ctx.Done():
+		l.Clock.Release()
+		return
+	}
+
+	l.mu.Lock()
+	reordered := seq < l.maxSeq // A later packet was delivered before
+	if !reordered {
+		l.maxSeq = seq
+	}
+	l.mu.Unlock()
+	if reordered {
+		l.ReorderedCount.Add(1)
+	}
+	l.OutCount.Add(1) // Increase by one the sent packets
+
 	debugL("Sent Packet")
-	wg.Done() // Update the information of the waitgroup
 }
@@ -0,0 +1,94 @@
+package mpthSim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// listJitter returns its samples in turn
+type listJitter struct {
+	samples []time.Duration
+	next    int
+}
+
+func (j *listJitter) Sample() time.Duration {
+	d := j.samples[j.next%len(j.samples)]
+	j.next++
+	return d
+}
+
+// runLink sends count one-byte payloads, numbered in order, through l and
+// returns them in the order they are delivered
+func runLink(t *testing.T, l *Link, count int) []byte {
+	t.Helper()
+	done := make(chan error)
+	go func() { done <- l.ProcessPackets(context.Background()) }()
+	l.Clock.Hold() // Send every payload at the same instant
+	for i := 0; i < count; i++ {
+		l.Clock.Hold() // The link releases it
+		l.In <- []byte{byte(i)}
+	}
+	l.Close()
+	l.Clock.Release()
+
+	var got []byte
+	for payload := range l.Out {
+		got = append(got, payload[0])
+		l.Clock.Release()
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestLinkJitterOrder(t *testing.T) {
+	const count = 5
+	// The later a payload enters, the shorter its jitter
+	decreasing := []time.Duration{50 * time.Millisecond, 40 * time.Millisecond,
+		30 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond}
+	clocks := map[string]func() Clock{
+		"real":    func() Clock { return RealClock{} },
+		"virtual": func() Clock { return NewVirtualClock(time.Unix(0, 0)) },
+	}
+	for name, clock := range clocks {
+		for _, reorder := range []bool{false, true} {
+			l := NewLink(0, 0)
+			l.Clock = clock()
+			l.SetJitter(&listJitter{samples: decreasing}, reorder)
+			got := runLink(t, l, count)
+			if len(got) != count {
+				t.Fatalf("%s clock, reorder %v: %d payloads delivered, want %d", name, reorder, len(got), count)
+			}
+			for i, p := range got {
+				want := byte(i)
+				if reorder {
+					want = byte(count - 1 - i)
+				}
+				if p != want {
+					t.Errorf("%s clock, reorder %v: delivered %v", name, reorder, got)
+					break
+				}
+			}
+			reordered := l.Stats().Reordered
+			if reorder && reordered != count-1 || !reorder && reordered != 0 {
+				t.Errorf("%s clock, reorder %v: %d payloads counted as reordered", name, reorder, reordered)
+			}
+		}
+	}
+}
+
+func TestLinkNegativeJitter(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewVirtualClock(start)
+	l := NewLink(0, 10*time.Millisecond)
+	l.Clock = clock
+	l.SetJitter(&listJitter{samples: []time.Duration{-time.Second}}, false)
+	if got := runLink(t, l, 1); len(got) != 1 {
+		t.Fatalf("%d payloads delivered, want 1", len(got))
+	}
+	if l.lastDelay != 0 || clock.Since(start) != 0 {
+		t.Errorf("delay %v, delivered after %v, want both clamped to 0", l.lastDelay, clock.Since(start))
+	}
+}
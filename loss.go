@@ -0,0 +1,81 @@
+package mpthSim
+
+import (
+	"math/rand"
+	"time"
+)
+
+// LossModel decides which of the packets entering a Link are lost
+type LossModel interface {
+	// Lost reports whether the packet entering the link at time now is lost
+	Lost(now time.Time) bool
+}
+
+// Bernoulli is a loss model where every packet is lost independently with
+// probability P
+type Bernoulli struct {
+	P   float64
+	rng *rand.Rand
+}
+
+// NewBernoulli creates an i.i.d. loss model with loss probability p. Its random
+// source is seeded from the global one, so runs are reproducible regardless of
+// goroutine scheduling.
+func NewBernoulli(p float64) *Bernoulli {
+	return &Bernoulli{P: p, rng: newRand()}
+}
+
+// Lost draws an independent loss with probability b.P
+func (b *Bernoulli) Lost(now time.Time) bool {
+	return b.rng.Float64() < b.P
+}
+
+// GilbertElliott is a two-state Markov loss model for bursty channels. In the
+// good state a packet is delivered with probability K, and in the bad state
+// with probability H. After each packet the channel moves from good to bad
+// with probability P and from bad to good with probability R.
+type GilbertElliott struct {
+	P, R, K, H float64
+	Bad        bool
+	rng        *rand.Rand
+}
+
+// NewGilbertElliott creates a Gilbert-Elliott loss model. The initial state is
+// drawn from the stationary distribution of the chain.
+func NewGilbertElliott(p, r, k, h float64) *GilbertElliott {
+	g := &GilbertElliott{P: p, R: r, K: k, H: h}
+	g.rng = newRand()
+	if p+r > 0 {
+		g.Bad = g.rng.Float64() < p/(p+r)
+	}
+	return g
+}
+
+// Lost draws the fate of the packet in the current state and then moves the
+// chain to its next state
+func (g *GilbertElliott) Lost(now time.Time) bool {
+	delivery := g.K
+	if g.Bad {
+		delivery = g.H
+	}
+	lost := g.rng.Float64() >= delivery
+
+	if g.Bad {
+		g.Bad = g.rng.Float64() >= g.R
+	} else {
+		g.Bad = g.rng.Float64() < g.P
+	}
+	return lost
+}
+
+// MeanLoss returns the stationary loss probability of the model
+func (g *GilbertElliott) MeanLoss() float64 {
+	if g.P+g.R == 0 {
+		if g.Bad {
+			return 1 - g.H
+		}
+		return 1 - g.K
+	}
+	piBad := g.P / (g.P + g.R)
+	return (1-piBad)*(1-g.K) + piBad*(1-g.H)
+}
@@ -1,12 +1,11 @@
 package mpthSim
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
-	"time"
-
-	"gitlab.com/steinwurf/kodo-go/src/kodo"
 
 	dbg "github.com/JuanCabre/go-debug"
 )
@@ -21,42 +20,119 @@ type Node struct {
 	InputsCount uint32
 	InputLinks  []*Link
 	OutputLinks []*Link
-	// TTL is the time to live for a recoder
-	Done      chan struct{}
-	ResetChan chan struct{}
-	// Transmission rate in B/s
+	// Done is closed once the node is no longer needed, i.e., when a decoder is
+	// complete, or when every node fed by a recoder or an encoder is done
+	Done chan struct{}
+	// Transmission rate in B/s of the outputs added with AddOutput
 	rate    uint64
-	Encoder *kodo.Encoder
-	Decoder *kodo.Decoder
+	Encoder Encoder
+	Decoder Decoder
 	Data    []byte
 
-	NodeID    byte
-	RxPackets []uint32
-
-	Transmissions uint64
-
-	mu sync.Mutex
-}
-
-type payloadWriter interface {
-	WritePayload(*uint8) uint32
-	PayloadSize() uint32
-	Rank() uint32
+	// Window is the number of generations the encoder sends at once, or all of
+	// them if zero. It is 1 by default.
+	Window uint32
+	// Delivered is the number of generations a decoder has delivered in order
+	Delivered uint32
+	// OnDeliver, if set, is called by a decoder with the data of every
+	// generation, in order, as soon as it and all the previous ones are
+	// decoded. It must not call the methods of the node.
+	OnDeliver func(generation uint32, data []byte)
+
+	// In sliding-window mode, see window.go, DeliveredSymbols is the number of
+	// symbols a decoder has delivered in order, and OnDeliverSymbol, if set,
+	// is called with each of them as soon as it is decoded. OnEnterWindow, if
+	// set, is called by the encoder with every symbol that enters its coding
+	// window. They must not call the methods of the node.
+	DeliveredSymbols uint32
+	OnDeliverSymbol  func(symbol uint32, data []byte)
+	OnEnterWindow    func(symbol uint32)
+
+	// OnDrained, if set, is called once the node is done and every input link
+	// it has is closed, after delivering the payloads in flight. The node
+	// receives nothing more then, unless an input is added. It is called with
+	// n.Clock held, like the handling of a payload, and must not call the
+	// methods of the node.
+	OnDrained func()
+
+	// NodeID is the Source of the packets sent by the node, see Header
+	NodeID uint32
+
+	// WastedTransmissions counts the payloads sent to nodes that no longer
+	// needed them, because every decoder they feed is complete, e.g., while
+	// the feedback is on its way. FeedbackTransmissions counts the reports
+	// sent through the feedback links.
+	WastedTransmissions   Counter
+	FeedbackTransmissions Counter
+	// RankFeedback makes the node report to every node that feeds it how many
+	// innovative payloads of each generation it received from it, so that the
+	// sender stops sending a generation through the link once the node holds
+	// everything it can contribute, see feedback.go. SavedTransmissions counts
+	// the payloads the node did not send because of the reports it got.
+	RankFeedback       bool
+	SavedTransmissions Counter
+
+	// Scheduler, if set, splits the payloads of every tick among the outputs
+	// of the node, see scheduler.go. Otherwise every output gets one.
+	Scheduler Scheduler
+	// SystematicTransmissions counts the uncoded payloads sent by an encoder
+	// in systematic mode. The rest of its transmissions are coded.
+	SystematicTransmissions Counter
+
+	// Clock is the time source that paces the transmissions. It must be the
+	// same clock used by the links attached to the node.
+	Clock Clock
+
+	mu          sync.Mutex
+	doneOnce    sync.Once
+	drainedOnce sync.Once
+	outputs     []*Link                    // Every output ever added, see Transmissions
+	hops        uint8                      // Most hops of the packets received, see Header
+	sources     map[SourceKey]*SourceStats // See SourceStats
+	newInputs   chan struct{}              // Closed when AddInput replaces n.Inputs
+	openLinks   *uint32                    // Input links still open into n.Inputs
+	stopRun     context.CancelFunc         // Stops the running RecodeAndSend, see Reset
+	stopped     bool                       // The node was cancelled and sends nothing more
+	readers     sync.WaitGroup             // The readFeedback goroutines
+	owner       *Owner                     // Owner of the timers that pace the node
+
+	// Per-generation state, see generation.go
+	generations    uint32
+	encoderFactory EncoderFactory
+	decoderFactory DecoderFactory
+	encoders       []Encoder
+	decoders       map[uint32]Decoder
+	doneGens       map[uint32]bool
+	base           uint32 // First generation the encoder still sends
+
+	// Sliding-window state, see window.go
+	entered uint32 // Symbols that entered the window of the encoder
+	acked   uint32 // Symbols delivered by every decoder downstream
+
+	// Feedback state, see feedback.go
+	doneBelow uint32 // Every generation before it is done downstream
 }
 
 func newNode(rate uint64) *Node {
 	n := new(Node)
 	n.Done = make(chan struct{})
-	n.ResetChan = make(chan struct{})
+	n.newInputs = make(chan struct{})
+	n.generations = 1
+	n.Window = 1
+	n.doneGens = make(map[uint32]bool)
 	n.rate = rate
+	n.Clock = RealClock{}
+	n.owner = NewOwner()
 	return n
 }
 
-// NewEncoderNode creates a node with a kodo Encoder. It takes an encoder
-// factory as an argument, which it uses to create the encoder
-func NewEncoderNode(factory *kodo.EncoderFactory, rate uint64) *Node {
+// NewEncoderNode creates a node with an Encoder. It takes an encoder factory
+// as an argument, which it uses to create the encoder
+func NewEncoderNode(factory EncoderFactory, rate uint64) *Node {
 	n := newNode(rate)
+	n.encoderFactory = factory
 	n.Encoder = factory.Build()
+	n.encoders = []Encoder{n.Encoder}
 	n.Data = make([]byte, n.Encoder.BlockSize())
 	return n
 }
@@ -64,184 +140,559 @@ func NewEncoderNode(factory *kodo.EncoderFactory, rate uint64) *Node {
 // SetConstSymbols should be called after the n.Data slice have been filled with
 // the desired data
 func (n *Node) SetConstSymbols() {
-	n.Encoder.SetConstSymbols(&n.Data[0], n.Encoder.BlockSize())
+	size := n.Encoder.BlockSize()
+	for g, e := range n.encoders {
+		e.SetConstSymbols(n.Data[uint32(g)*size : uint32(g+1)*size])
+	}
 }
 
-// NewDecoderNode creates a node with a kodo Encoder. It takes an encoder
-// factory as an argument, which it uses to create the encoder
-func NewDecoderNode(factory *kodo.DecoderFactory, rate uint64) *Node {
+// SetSystematic turns the systematic mode of the encoder on or off. When on,
+// the first pass over the block is sent uncoded and coded repair payloads
+// follow. It returns an error if it is turned on and the encoder does not
+// support it.
+func (n *Node) SetSystematic(on bool) error {
+	for _, e := range n.encoders {
+		s, ok := e.(systematicEncoder)
+		if !ok && on {
+			return errors.New("mpthSim: the encoder has no systematic mode")
+		} else if !ok {
+			continue
+		}
+		if on {
+			s.SetSystematicOn()
+		} else {
+			s.SetSystematicOff()
+		}
+	}
+	return nil
+}
+
+// NewDecoderNode creates a node with a Decoder. It takes a decoder factory as
+// an argument, which it uses to create the decoder
+func NewDecoderNode(factory DecoderFactory, rate uint64) *Node {
 	n := newNode(rate)
-	n.RxPackets = make([]uint32, 3)
+	n.decoderFactory = factory
 	n.Decoder = factory.Build()
+	n.decoders = map[uint32]Decoder{0: n.Decoder}
 	n.Data = make([]byte, n.Decoder.BlockSize())
-	n.Decoder.SetMutableSymbols(&n.Data[0], n.Decoder.BlockSize())
+	n.Decoder.SetMutableSymbols(n.Data)
 	return n
 }
 
-// NewRecoderNode creates a node with a kodo Encoder. It takes an encoder
-// factory as an argument, which it uses to create the encoder
-func NewRecoderNode(factory *kodo.DecoderFactory, rate uint64) *Node {
+// NewRecoderNode creates a node with a Decoder, which it uses to recode. It
+// takes a decoder factory as an argument, which it uses to create the decoder
+func NewRecoderNode(factory DecoderFactory, rate uint64) *Node {
 	n := newNode(rate)
-	n.RxPackets = make([]uint32, 3)
+	n.decoderFactory = factory
 	n.Decoder = factory.Build()
+	n.decoders = map[uint32]Decoder{0: n.Decoder}
 	n.Data = make([]byte, n.Decoder.BlockSize())
-	n.Decoder.SetMutableSymbols(&n.Data[0], n.Decoder.BlockSize())
+	n.Decoder.SetMutableSymbols(n.Data)
 	return n
 }
 
+// AddInput makes the node receive the payloads coming out of the link l
 func (n *Node) AddInput(l *Link) {
 
+	n.mu.Lock()
 	n.InputLinks = append(n.InputLinks, l)
 	n.InputsWg.Add(1)
-	// The first time it is called...
+	// The first time it is called, once all the previous input links are
+	// closed and so is n.Inputs, or after a Reset...
 	if n.InputsCount == 0 {
-		// ...start a goroutine to close n.Inputs once all the output goroutines
-		// are done. This must start after the wg.Add call.
-		go func() {
-			n.InputsWg.Wait()
-			close(n.Inputs)
-		}()
-		// Create the input channel
+		// ...create a new input channel and tell the reader of the node
 		n.Inputs = make(chan []byte, 10000)
+		n.openLinks = new(uint32)
+		close(n.newInputs)
+		n.newInputs = make(chan struct{})
 	}
 	n.InputsCount++
+	*n.openLinks++
+	inputs, open := n.Inputs, n.openLinks
+	n.mu.Unlock()
+	l.setTo(n)
 
 	// Start an output goroutine for each new input channel. merger copies
-	// values from c to n.Inputs until c is closed, then calls n.InputsWg.Done.
+	// values from c to inputs until c is closed, then calls n.InputsWg.Done.
+	// The last merger of inputs closes it after that, so once the reader sees
+	// inputs closed every merger of inputs is done. The values stay held in
+	// n.Clock until the node reads them from inputs, and the closing of c
+	// until the merger handles it, see Link.Close. A Reset forgets inputs,
+	// whose mergers no longer count in n.InputsCount then.
 	merger := func(c <-chan []byte) {
 		for val := range c {
-			n.Inputs <- val
+			inputs <- val
+		}
+		n.mu.Lock()
+		if l.Feedback != nil && !l.feedbackClosed {
+			l.Feedback.Close()
+			l.feedbackClosed = true
 		}
 		n.InputsWg.Done()
-		n.InputsCount--
+		if inputs == n.Inputs {
+			n.InputsCount--
+			n.checkDrained()
+		}
+		if *open--; *open == 0 {
+			close(inputs)
+		}
+		n.mu.Unlock()
+		n.Clock.Release() // The closing of c, see Link.Close
 	}
 	go merger(l.Out)
 
 }
 
+// readInputs calls handle with every payload received by the node from inputs,
+// n.Inputs when the reader started, which next replaces. When inputs is
+// closed, it follows the next input channel created by AddInput, e.g., after
+// the upstream links are rebuilt, until the node is done. Once reset is
+// closed, or ctx is cancelled, it drops the payloads of the current input
+// channel until it is closed, so that a virtual clock does not wait for them,
+// and returns nil or ctx.Err() respectively. The payloads in flight through
+// the links replaced by a Reset are thus lost with the node.
+func (n *Node) readInputs(ctx context.Context, inputs chan []byte, next, reset <-chan struct{}, handle func(payload []byte)) error {
+	for {
+		for inputs != nil {
+			payload, ok := <-inputs
+			switch {
+			case !ok:
+				inputs = nil
+			case ctx.Err() != nil || isClosed(reset):
+				n.Clock.Release() // Dropped
+			default:
+				handle(payload)
+			}
+		}
+		if isClosed(reset) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case <-n.Done:
+			return nil
+		case <-reset:
+			return nil
+		case <-ctx.Done():
+		case <-next: // A new input channel
+		}
+		n.mu.Lock()
+		inputs, next = n.Inputs, n.newInputs
+		n.mu.Unlock()
+	}
+}
+
+// isClosed reports whether the channel c is closed, and false if it is nil
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return c != nil
+	default:
+		return false
+	}
+}
+
+// AddOutput makes the node send its payloads through the link l at the rate
+// of the node. A node that is done, or whose sender was cancelled, sends
+// nothing more, so it closes l at once.
 func (n *Node) AddOutput(l *Link) {
+	n.AddOutputWithRate(l, n.rate)
+}
+
+// AddOutputWithRate is AddOutput with the rate in B/s at which the node sends
+// through l, e.g., the rate of the modem of a path. The payloads of every
+// output are paced on their own, see pace.
+func (n *Node) AddOutputWithRate(l *Link, rate uint64) {
+	n.mu.Lock()
+	if n.isDone() || n.stopped {
+		l.Close()
+		n.mu.Unlock()
+		return
+	}
+	l.rate = rate
+	l.nextSend = n.Clock.Now().Add(n.period(rate))
 	n.OutputLinks = append(n.OutputLinks, l)
+	n.outputs = append(n.outputs, l)
+	n.mu.Unlock()
+	l.setFrom(n)
+	if l.Feedback != nil {
+		n.readers.Add(1)
+		go func() {
+			defer n.readers.Done()
+			n.readFeedback(l)
+		}()
+	}
+}
+
+// finish closes n.Done, unless it is already closed, and tells the nodes that
+// feed n, so they can stop too. Done thus propagates from the decoders back to
+// the encoder through chains of recoders of any length.
+func (n *Node) finish() {
+	n.mu.Lock()
+	n.closeDone()
+	n.sendFeedback(false)
+	inputs := append([]*Link(nil), n.InputLinks...)
+	n.mu.Unlock()
+	for _, l := range inputs {
+		if from := l.sender(); from != nil {
+			from.checkDone()
+		}
+	}
+}
+
+// closeDone closes n.Done, unless it is already closed. n.mu must be held, so
+// that the node is done before its next report, which must not tell the
+// senders that every generation is done without telling them the node is: if
+// that last report were lost, they would stop sending to the node, which
+// would never send it again.
+func (n *Node) closeDone() {
+	n.doneOnce.Do(func() {
+		select {
+		case <-n.Done: // Closed by the user of the node to stop it early
+		default:
+			close(n.Done)
+		}
+	})
+	n.checkDrained()
+}
+
+// checkDrained calls OnDrained once the node is done and all its input links
+// are closed. n.mu must be held.
+func (n *Node) checkDrained() {
+	if n.OnDrained != nil && n.InputsCount == 0 && n.isDone() {
+		n.drainedOnce.Do(n.OnDrained)
+	}
+}
+
+// checkDone finishes the node if every node it sends to is done
+func (n *Node) checkDone() {
+	if n.isDone() {
+		return
+	}
+	n.mu.Lock()
+	done := n.outputsDone()
+	n.mu.Unlock()
+	if done {
+		n.finish()
+	}
+}
+
+// outputsDone reports whether every node the node sends to is done, as far as
+// it knows. n.mu must be held.
+func (n *Node) outputsDone() bool {
+	for _, out := range n.OutputLinks {
+		if !n.outputDone(out) {
+			return false
+		}
+	}
+	return len(n.OutputLinks) > 0
+}
+
+// isDone reports whether n.Done is closed
+func (n *Node) isDone() bool {
+	select {
+	case <-n.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Transmissions returns the number of payloads the node sent through all the
+// outputs it ever had, see Link.Transmissions
+func (n *Node) Transmissions() uint64 {
+	return n.Stats().Transmissions
+}
+
+// RemoveOutput stops sending through the link l and closes its input channel,
+// e.g., when the link loses visibility. The receiving node detaches from the
+// link once it has delivered the packets in flight. It does nothing if l is
+// not an output of the node.
+func (n *Node) RemoveOutput(l *Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, out := range n.OutputLinks {
+		if out == l {
+			n.OutputLinks = append(n.OutputLinks[:i:i], n.OutputLinks[i+1:]...)
+			l.Close()
+			return
+		}
+	}
 }
 
 // SendEncodedPackets produces encoded packets and sends them through all the
-// output channels
-func (n *Node) SendEncodedPackets() {
-	t := float64(n.Encoder.SymbolSize()) / float64(n.rate) * 1000000000 //nS
-	debugN("Sending a packet every %v", time.Duration(t)*time.Nanosecond)
+// output channels until the node is done or ctx is cancelled. Then it closes
+// the outputs and returns ctx.Err(), if any, once the feedback of every output
+// has been read. It must be started with n.Clock.Go so that a virtual clock
+// accounts for it.
+func (n *Node) SendEncodedPackets(ctx context.Context) error {
+	debugN("Sending a packet every %v by default", n.period(n.rate))
 
 	for {
-		select {
-		case <-n.Done: // The decoder is ready
-			for _, output := range n.OutputLinks {
-				go close(output.In)
+		n.Clock.Sleep(n.owner, n.pace())
+		n.mu.Lock()
+		if n.isDone() || ctx.Err() != nil {
+			if n.isDone() { // The decoder is ready
+				fmt.Println("Encoder: Got signal done from decoder")
 			}
-			fmt.Println("Encoder: Got signal done from decoder")
-			return
-		case <-time.After(time.Duration(t) * time.Nanosecond):
-			n.sendPayloads(n.Encoder)
+			n.stopOutputs(ctx)
+			n.mu.Unlock()
+			n.wait(&n.readers)
+			return ctx.Err()
 		}
+		n.sendPayloads()
+		n.mu.Unlock()
 	}
 }
 
-func (n *Node) RecodeAndSend() {
-	t := float64(n.Decoder.SymbolSize()) / float64(n.rate) * 1000000000 //nS
+// RecodeAndSend reads the incoming packets and sends recoded packets through
+// all the output channels until the node is done, reset or ctx is cancelled.
+// Unless it is reset, it then closes the outputs and returns ctx.Err(), if
+// any, once the inputs and the feedback of every output are closed. After a
+// Reset, which closes the outputs itself, it returns nil once the inputs the
+// node had are closed, dropping the payloads still in flight through them.
+// It must be started with n.Clock.Go so that a virtual clock accounts for it.
+func (n *Node) RecodeAndSend(ctx context.Context) error {
 	fmt.Println("Recoder started")
 
+	// Reset cancels reset, which is not derived from ctx so that the reader
+	// tells a reset from the end of the run
+	reset, stop := context.WithCancel(context.Background())
+	defer stop()
+	// The inputs of the run, taken now since the reader may only start after
+	// a Reset replaces them
+	n.mu.Lock()
+	n.stopRun = stop
+	inputs, next := n.Inputs, n.newInputs
+	n.mu.Unlock()
+
 	// Constantly read packets
+	var reader sync.WaitGroup
+	reader.Add(1)
 	go func() {
-		for payload := range n.Inputs {
+		defer reader.Done()
+		n.readInputs(ctx, inputs, next, reset.Done(), func(payload []byte) {
+			h, coded, err := ParseHeader(payload)
+			if err != nil {
+				debugN("Dropped a packet: %v", err)
+				n.Clock.Release()
+				return
+			}
 			n.mu.Lock()
-			n.Decoder.ReadPayload(&payload[0])
+			if h.Generation >= n.generations {
+				debugN("Dropped a packet of generation %d of %d", h.Generation, n.generations)
+				n.recordPacket(h, false)
+			} else if n.downstreamDone(h.Generation) {
+				n.recordPacket(h, false)
+				n.sendFeedback(true) // The sender may have missed it
+			} else {
+				innovative := n.readPayload(n.decoder(h.Generation), h, coded)
+				n.recordPacket(h, innovative)
+				n.sendFeedback(!innovative)
+			}
 			n.mu.Unlock()
+			n.Clock.Release()
 			// fmt.Println("Recoder rank: ", n.Decoder.Rank())
-		}
+		})
 	}()
 
 	for {
-		select {
-		case <-n.Done: // The decoder is ready
-			for _, output := range n.OutputLinks {
+		n.Clock.Sleep(n.owner, n.pace())
+		n.mu.Lock()
+		switch {
+		case reset.Err() != nil: // A reset was triggered
+			n.mu.Unlock()
+			n.wait(&reader)
+			return nil
+		case n.isDone() || ctx.Err() != nil:
+			if n.isDone() { // The decoder is ready
 				fmt.Println("Recoder: Got signal done from decoder")
-				go close(output.In)
 			}
-			return
-		case <-n.ResetChan: // A reset was triggered
-			n.ResetChan = make(chan struct{})
-			return
-		case <-time.After(time.Duration(t) * time.Nanosecond): // Send a payload
-			n.mu.Lock()
-			n.sendPayloads(n.Decoder)
+			n.stopOutputs(ctx)
 			n.mu.Unlock()
+			n.wait(&reader, &n.readers)
+			return ctx.Err()
 		}
+		n.sendPayloads()
+		n.mu.Unlock()
 	}
 }
 
-func (n *Node) ReceiveCodedPackets(wg *sync.WaitGroup, done ...chan<- struct{}) {
-	doneIsClosed := false
+// ReceiveCodedPackets decodes the incoming packets until every generation is
+// decoded, and delivers them in order. Then it closes n.Done, which propagates
+// to every node that feeds the decoder through the links. It returns once the
+// inputs are closed, nil if the decoder is complete, and ctx.Err() if ctx was
+// cancelled before.
+func (n *Node) ReceiveCodedPackets(ctx context.Context) error {
+	n.mu.Lock()
+	inputs, next := n.Inputs, n.newInputs
+	n.mu.Unlock()
+	err := n.readInputs(ctx, inputs, next, nil, func(payload []byte) {
+		h, coded, err := ParseHeader(payload)
+		if err != nil {
+			debugN("Dropped a packet: %v", err)
+			n.Clock.Release()
+			return
+		}
+		if n.isDone() {
+			n.mu.Lock()
+			n.recordPacket(h, false)
+			n.sendFeedback(true) // The sender may have missed it
+			n.mu.Unlock()
+			n.Clock.Release()
+			return
+		}
 
-	for !n.Decoder.IsComplete() {
-		for payload := range n.Inputs {
-			if doneIsClosed {
-				continue
+		n.mu.Lock()
+		if d, ok := n.decoders[h.Generation]; ok && !d.IsComplete() {
+			innovative := n.readPayload(d, h, coded)
+			n.recordPacket(h, innovative)
+			n.deliverSymbols(d)
+			if d.IsComplete() {
+				n.doneGens[h.Generation] = true
+				n.deliver()
 			}
-			n.Decoder.ReadPayload(&payload[0])
-			id := payload[len(payload)-1]
-			n.RxPackets[id]++
-			if n.Decoder.IsComplete() {
-				// Close all done channels
-				for _, d := range done {
-					close(d)
-				}
-				doneIsClosed = true
-				log.Println("Decoder is complete!")
-				// break
+			if n.Delivered == n.generations {
+				n.closeDone() // Reported along with the last generation
 			}
+			n.sendFeedback(!innovative)
+		} else {
+			n.recordPacket(h, false)
+			n.sendFeedback(true) // The sender may have missed it
 		}
+		complete := n.Delivered == n.generations
+		n.mu.Unlock()
+
+		if complete {
+			n.finish()
+			log.Println("Decoder is complete!")
+		}
+		n.Clock.Release()
+	})
+	if n.isDone() {
+		return nil
 	}
-	wg.Done()
+	return err
 }
 
-func (n *Node) Reset(factory *kodo.DecoderFactory) {
+// Reset stops the running RecodeAndSend of a recoder, closes its outputs,
+// forgets its inputs and rebuilds its recoders with factory. The senders close
+// the input links at their next payload, and the links attached again before
+// the next RecodeAndSend feed a new input channel.
+func (n *Node) Reset(factory DecoderFactory) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	close(n.ResetChan) // Signal a reset
+	if n.stopRun != nil {
+		n.stopRun() // Signal a reset
+	}
 	fmt.Println("Recoder Reset")
 	for _, input := range n.InputLinks {
+		input.mu.Lock()
 		input.DestGone = nil
+		input.mu.Unlock()
 	}
+	n.Inputs = nil
+	n.InputsCount = 0
 
 	// Close all current outputs
 	for _, output := range n.OutputLinks {
-		close(output.In)
+		output.Close()
 	}
 	// Reset the Outputs array
 	n.OutputLinks = make([]*Link, 0)
 	n.InputLinks = make([]*Link, 0)
+	n.hops = 0
 
-	defer kodo.DeleteDecoder(n.Decoder) // Delete the recoder
+	// Delete the recoder of every generation
+	for g := range n.decoders {
+		n.deleteDecoder(g)
+	}
+	if d, ok := n.Decoder.(deleter); ok {
+		defer d.Delete()
+	}
 
+	n.decoderFactory = factory
 	n.Decoder = factory.Build() // Rebuild the recoder
+	n.decoders = map[uint32]Decoder{0: n.Decoder}
 	n.Data = make([]byte, n.Decoder.BlockSize())
-	n.Decoder.SetMutableSymbols(&n.Data[0], n.Decoder.BlockSize())
+	n.Decoder.SetMutableSymbols(n.Data)
 }
 
-func (n *Node) sendPayloads(coder payloadWriter) {
-	if coder.Rank() == 0 {
-		return
+// stopOutputs closes the outputs of the node, which sends nothing more. If ctx
+// is cancelled, the outputs added later are closed at once as well. n.mu must
+// be held.
+func (n *Node) stopOutputs(ctx context.Context) {
+	for _, output := range n.OutputLinks {
+		output.Close()
 	}
+	n.OutputLinks = nil
+	n.stopped = ctx.Err() != nil
+}
 
+// wait blocks until the wait groups are done. The caller must be held in
+// n.Clock, which it releases meanwhile as Sleep does.
+func (n *Node) wait(groups ...*sync.WaitGroup) {
+	n.Clock.Release()
+	for _, wg := range groups {
+		wg.Wait()
+	}
+	n.Clock.Hold()
+}
+
+// sendPayloads sends the payloads of a tick through the outputs that are due,
+// see schedule, each of the next generation in flight. n.mu must be held.
+func (n *Node) sendPayloads() {
+	// Drop the outputs whose destination is gone first, so that nextCoder
+	// only looks at the live ones
 	tmpOutputs := n.OutputLinks[:0]
-	for i, out := range n.OutputLinks {
-		if n.OutputLinks[i].DestGone != nil {
+	for _, out := range n.OutputLinks {
+		out.mu.Lock()
+		gone := out.DestGone == nil
+		out.mu.Unlock()
+		if !gone {
 			tmpOutputs = append(tmpOutputs, out)
-			payload := make([]byte, coder.PayloadSize()+1) // Payload size plus nodeID
-			coder.WritePayload(&payload[0])
-			payload[len(payload)-1] = n.NodeID // Append the nodeID
-			out.In <- payload
-			n.Transmissions++
 		} else {
-			close(out.In)
+			out.Close()
 		}
 	}
 	n.OutputLinks = tmpOutputs
+
+	for i, count := range n.schedule() {
+		for ; count > 0; count-- {
+			n.sendPayload(n.OutputLinks[i])
+		}
+	}
+}
+
+// sendPayload sends a payload of the next generation in flight through the
+// output out, if there is any. n.mu must be held.
+func (n *Node) sendPayload(out *Link) {
+	g, coder := n.nextCoder(out)
+	if coder == nil || coder.Rank() == 0 {
+		return
+	}
+	h := Header{
+		Version:    HeaderVersion,
+		Source:     n.NodeID,
+		Path:       out.ID,
+		Generation: g,
+		Seq:        uint32(out.Transmissions.Load()),
+		Sent:       n.Clock.Now(),
+		Hops:       n.hops + 1,
+	}
+	payload := make([]byte, HeaderSize+coder.PayloadSize())
+	h.Marshal(payload)
+	if s, ok := coder.(systematicEncoder); ok && s.InSystematicPhase() {
+		n.SystematicTransmissions.Add(1)
+	}
+	// Payloads with sparse or seed coefficients are shorter than PayloadSize
+	payload = payload[:HeaderSize+coder.WritePayload(payload[HeaderSize:])]
+	n.Clock.Hold() // The link releases it
+	out.In <- payload
+	out.Transmissions.Add(1)
+	if to := out.receiver(); to != nil && to.completed() {
+		n.WastedTransmissions.Add(1)
+	}
 }
@@ -0,0 +1,251 @@
+package mpthSim
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JuanCabre/mpthSim/rlnc"
+)
+
+// TestSendPayloadTruncated checks that the packets cut to the size written by
+// the encoder, shorter than PayloadSize with sparse or seed coefficients, keep
+// the whole coded payload
+func TestSendPayloadTruncated(t *testing.T) {
+	const symbols, symbolSize = 32, 50
+	for _, encoding := range []rlnc.Encoding{rlnc.Sparse, rlnc.Seed} {
+		factory := NewRLNCSparseEncoderFactory(rlnc.Binary8, symbols, symbolSize, 0.2)
+		factory.Encoding = encoding
+		n := NewEncoderNode(factory, 1000)
+		n.NodeID = 5
+		rand.Read(n.Data)
+		n.SetConstSymbols()
+		out := NewLink(0, 0)
+		out.ID = 2
+		n.AddOutput(out)
+
+		d := rlnc.NewDecoder(rlnc.Binary8, symbols, symbolSize)
+		d.SetEncoding(encoding)
+		data := make([]byte, d.BlockSize())
+		d.SetMutableSymbols(data)
+		truncated := false
+		for seq := uint32(0); !d.IsComplete() && seq < 10*symbols; seq++ {
+			n.mu.Lock()
+			n.sendPayload(out)
+			n.mu.Unlock()
+			h, payload, err := ParseHeader(<-out.In)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Source != n.NodeID || h.Path != out.ID || h.Seq != seq {
+				t.Fatalf("encoding %d: header %+v of payload %d", encoding, h, seq)
+			}
+			truncated = truncated || len(payload) < int(n.Encoder.PayloadSize())
+			d.ReadPayload(payload)
+		}
+		if !truncated {
+			t.Errorf("encoding %d: no payload shorter than PayloadSize", encoding)
+		}
+		if !d.IsComplete() || !bytes.Equal(data, n.Data) {
+			t.Errorf("encoding %d: block not decoded from the truncated payloads", encoding)
+		}
+	}
+}
+
+// TestRecoderResetVirtual resets a recoder with a downtime on a virtual clock
+// while payloads are in flight to it, and checks that the transfer completes
+func TestRecoderResetVirtual(t *testing.T) {
+	const symbols, symbolSize, rate = 16, 100, 100000 // A payload every ms
+	ctx := context.Background()
+	clock := NewVirtualClock(time.Unix(0, 0))
+	decoders := NewRLNCDecoderFactory(rlnc.Binary8, symbols, symbolSize)
+	enc := NewEncoderNode(NewRLNCEncoderFactory(rlnc.Binary8, symbols, symbolSize), rate)
+	rand.Read(enc.Data)
+	enc.SetConstSymbols()
+	rec := NewRecoderNode(decoders, rate)
+	dec := NewDecoderNode(decoders, rate)
+	for _, n := range []*Node{enc, rec, dec} {
+		n.Clock = clock
+	}
+
+	var links, tasks sync.WaitGroup
+	newLink := func() *Link {
+		l := NewLink(0.1, 5*time.Millisecond)
+		l.Clock = clock
+		links.Add(1)
+		go func() {
+			defer links.Done()
+			l.ProcessPackets(ctx)
+		}()
+		return l
+	}
+	goTask := func(f func()) {
+		tasks.Add(1)
+		clock.Go(func() {
+			defer tasks.Done()
+			f()
+		})
+	}
+
+	clock.Hold() // Set everything up before the time advances
+	in, out := newLink(), newLink()
+	rec.AddInput(in)
+	enc.AddOutput(in)
+	dec.AddInput(out)
+	rec.AddOutput(out)
+	decoded := make(chan error, 1)
+	go func() { decoded <- dec.ReceiveCodedPackets(ctx) }()
+	goTask(func() { rec.RecodeAndSend(ctx) })
+	goTask(func() { enc.SendEncodedPackets(ctx) })
+	goTask(func() {
+		clock.Sleep(nil, 3*time.Millisecond) // Payloads in flight to the recoder
+		rec.Reset(decoders)
+		in, out := newLink(), newLink()
+		rec.AddInput(in)
+		rec.AddOutput(out)
+		clock.Sleep(nil, 10*time.Millisecond) // They arrive meanwhile
+		enc.AddOutput(in)
+		dec.AddInput(out)
+		goTask(func() { rec.RecodeAndSend(ctx) })
+	})
+	clock.Release()
+
+	select {
+	case err := <-decoded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the transfer did not complete after the reset")
+	}
+	tasks.Wait()
+	links.Wait()
+	if !bytes.Equal(dec.Data, enc.Data) {
+		t.Error("block not decoded correctly")
+	}
+}
+
+// TestSlidingWindowInOrder sends a stream in sliding-window mode from an
+// encoder through a recoder to a decoder over lossy links on a virtual clock,
+// and checks that the decoder delivers every symbol once, in order
+func TestSlidingWindowInOrder(t *testing.T) {
+	const symbols, symbolSize, window, rate = 64, 20, 8, 100000
+	ctx := context.Background()
+	clock := NewVirtualClock(time.Unix(0, 0))
+	enc := NewEncoderNode(NewRLNCWindowEncoderFactory(rlnc.Binary8, symbols, symbolSize, window), rate)
+	rand.Read(enc.Data)
+	enc.SetConstSymbols()
+	decoders := NewRLNCWindowDecoderFactory(rlnc.Binary8, symbols, symbolSize, window)
+	rec := NewRecoderNode(decoders, rate)
+	dec := NewDecoderNode(decoders, rate)
+	for _, n := range []*Node{enc, rec, dec} {
+		n.Clock = clock
+	}
+	var next uint32
+	dec.OnDeliverSymbol = func(i uint32, data []byte) {
+		if i != next {
+			t.Errorf("symbol %d delivered after %d symbols", i, next)
+		}
+		if !bytes.Equal(data, enc.Data[i*symbolSize:(i+1)*symbolSize]) {
+			t.Errorf("symbol %d delivered with other data", i)
+		}
+		next = i + 1
+	}
+
+	var links, tasks sync.WaitGroup
+	newLink := func() *Link {
+		l := NewLink(0.2, 5*time.Millisecond)
+		l.Clock = clock
+		links.Add(1)
+		go func() {
+			defer links.Done()
+			l.ProcessPackets(ctx)
+		}()
+		return l
+	}
+	goTask := func(f func()) {
+		tasks.Add(1)
+		clock.Go(func() {
+			defer tasks.Done()
+			f()
+		})
+	}
+
+	clock.Hold() // Set everything up before the time advances
+	in, out := newLink(), newLink()
+	rec.AddInput(in)
+	enc.AddOutput(in)
+	dec.AddInput(out)
+	rec.AddOutput(out)
+	decoded := make(chan error, 1)
+	go func() { decoded <- dec.ReceiveCodedPackets(ctx) }()
+	goTask(func() { rec.RecodeAndSend(ctx) })
+	goTask(func() { enc.SendEncodedPackets(ctx) })
+	clock.Release()
+
+	select {
+	case err := <-decoded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the stream did not complete, %d symbols delivered", next)
+	}
+	tasks.Wait()
+	links.Wait()
+	if next != symbols || dec.DeliveredSymbols != symbols {
+		t.Errorf("%d symbols delivered, want %d", next, symbols)
+	}
+}
+
+// TestRecoderDropsUnknownGeneration checks that a recoder drops the payloads
+// of the generations past the ones of the transfer instead of recoding them
+func TestRecoderDropsUnknownGeneration(t *testing.T) {
+	const symbols, symbolSize = 8, 10
+	enc := rlnc.NewEncoder(rlnc.Binary8, symbols, symbolSize)
+	data := make([]byte, symbols*symbolSize)
+	rand.Read(data)
+	enc.SetConstSymbols(data)
+	clock := NewVirtualClock(time.Unix(0, 0))
+	rec := NewRecoderNode(NewRLNCDecoderFactory(rlnc.Binary8, symbols, symbolSize), 1000)
+	rec.Clock = clock
+	rec.SetGenerations(2)
+	in := NewLink(0, 0)
+	in.Clock = clock
+	rec.AddInput(in)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	clock.Hold() // Set everything up before the time advances
+	clock.Go(func() { done <- rec.RecodeAndSend(ctx) })
+	for _, g := range []uint32{1, 2, 5} {
+		packet := make([]byte, HeaderSize+int(enc.PayloadSize()))
+		h := Header{Version: HeaderVersion, Generation: g}
+		h.Marshal(packet)
+		enc.WritePayload(packet[HeaderSize:])
+		clock.Hold() // The recoder releases it
+		in.Out <- packet
+	}
+	clock.Hold() // The closing too, see Link.Close
+	close(in.Out)
+	clock.Go(func() {
+		clock.Sleep(nil, time.Millisecond) // Once the payloads are handled
+		cancel()
+	})
+	clock.Release()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(rec.decoders) != 2 || rec.decoders[1].Rank() != 1 {
+		t.Errorf("recoders of generations %v, want generation 1 of rank 1 and 0", rec.decoders)
+	}
+	for _, s := range rec.SourceStats() {
+		if s.Innovative != 1 || s.NonInnovative != 2 {
+			t.Errorf("%d innovative and %d other payloads, want 1 and the 2 dropped", s.Innovative, s.NonInnovative)
+		}
+	}
+}
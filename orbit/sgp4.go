@@ -0,0 +1,330 @@
+package orbit
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/JuanCabre/mpthSim/geo"
+)
+
+// WGS-72 constants used by SGP4
+const (
+	radiusEarthKm = 6378.135
+	muKm          = 398600.8
+	j2            = 0.001082616
+	j3            = -0.00000253881
+	j4            = -0.00000165597
+	j3oj2         = j3 / j2
+	x2o3          = 2.0 / 3.0
+	twoPi         = 2 * math.Pi
+	deg2rad       = math.Pi / 180
+)
+
+// xke is the square root of mu in earth radii^1.5 per minute
+var xke = 60 / math.Sqrt(radiusEarthKm*radiusEarthKm*radiusEarthKm/muKm)
+
+// Errors returned by Propagate
+var (
+	ErrEccentricity = errors.New("orbit: eccentricity out of range")
+	ErrMeanMotion   = errors.New("orbit: mean motion is not positive")
+	ErrDecayed      = errors.New("orbit: satellite has decayed")
+)
+
+// Satellite propagates the orbit given by a TLE with the SGP4 model. It only
+// reads its state once created, so it can be shared by several goroutines.
+//
+// Satellites with a period of 225 minutes or more, like GEO satellites, are
+// propagated with the near-earth secular and periodic terms and without the
+// lunar-solar and resonance terms of SDP4. The resulting error grows slowly
+// with the time since the epoch and is negligible for the propagation delays
+// over the duration of a simulation.
+type Satellite struct {
+	TLE *TLE
+	// DeepSpace is true for satellites with a period of 225 minutes or more
+	DeepSpace bool
+
+	// Mean elements at epoch in radians and radians per minute
+	ecco, argpo, inclo, mo, no, nodeo, bstar float64
+
+	isimp                                       bool
+	aycof, con41, cc1, cc4, cc5, d2, d3, d4     float64
+	delmo, eta, argpdot, omgcof, sinmao         float64
+	t2cof, t3cof, t4cof, t5cof, x1mth2          float64
+	x7thm1, mdot, nodedot, xlcof, xmcof, nodecf float64
+}
+
+// NewSatellite initializes the SGP4 propagator for the element set
+func NewSatellite(t *TLE) (*Satellite, error) {
+	s := &Satellite{TLE: t}
+	s.ecco = t.Eccentricity
+	s.argpo = t.ArgPerigee * deg2rad
+	s.inclo = t.Inclination * deg2rad
+	s.mo = t.MeanAnomaly * deg2rad
+	s.no = t.MeanMotion * twoPi / 1440 // rev/day to rad/min
+	s.nodeo = t.RAAN * deg2rad
+	s.bstar = t.BStar
+	if s.ecco < 0 || s.ecco >= 1 {
+		return nil, ErrEccentricity
+	}
+	if s.no <= 0 {
+		return nil, ErrMeanMotion
+	}
+	s.init()
+	return s, nil
+}
+
+// init computes the constants of the propagation that only depend on the
+// elements at epoch
+func (s *Satellite) init() {
+	// Recover the original mean motion and semi-major axis from the Kozai
+	// mean motion of the element set
+	eccsq := s.ecco * s.ecco
+	omeosq := 1 - eccsq
+	rteosq := math.Sqrt(omeosq)
+	cosio := math.Cos(s.inclo)
+	cosio2 := cosio * cosio
+
+	ak := math.Pow(xke/s.no, x2o3)
+	d1 := 0.75 * j2 * (3*cosio2 - 1) / (rteosq * omeosq)
+	del := d1 / (ak * ak)
+	adel := ak * (1 - del*del - del*(1.0/3.0+134*del*del/81))
+	del = d1 / (adel * adel)
+	s.no = s.no / (1 + del)
+
+	ao := math.Pow(xke/s.no, x2o3)
+	sinio := math.Sin(s.inclo)
+	po := ao * omeosq
+	con42 := 1 - 5*cosio2
+	s.con41 = -con42 - cosio2 - cosio2
+	posq := po * po
+	rp := ao * (1 - s.ecco)
+
+	s.DeepSpace = twoPi/s.no >= 225
+	s.isimp = rp < 220/radiusEarthKm+1 || s.DeepSpace
+
+	// Atmospheric drag parameters
+	ss := 78/radiusEarthKm + 1
+	qzms2t := math.Pow((120-78)/radiusEarthKm, 4)
+	sfour := ss
+	qzms24 := qzms2t
+	perige := (rp - 1) * radiusEarthKm
+	if perige < 156 {
+		sfour = perige - 78
+		if perige < 98 {
+			sfour = 20
+		}
+		qzms24 = math.Pow((120-sfour)/radiusEarthKm, 4)
+		sfour = sfour/radiusEarthKm + 1
+	}
+	pinvsq := 1 / posq
+
+	tsi := 1 / (ao - sfour)
+	s.eta = ao * s.ecco * tsi
+	etasq := s.eta * s.eta
+	eeta := s.ecco * s.eta
+	psisq := math.Abs(1 - etasq)
+	coef := qzms24 * math.Pow(tsi, 4)
+	coef1 := coef / math.Pow(psisq, 3.5)
+	cc2 := coef1 * s.no * (ao*(1+1.5*etasq+eeta*(4+etasq)) +
+		0.375*j2*tsi/psisq*s.con41*(8+3*etasq*(8+etasq)))
+	s.cc1 = s.bstar * cc2
+	cc3 := 0.0
+	if s.ecco > 1.0e-4 {
+		cc3 = -2 * coef * tsi * j3oj2 * s.no * sinio / s.ecco
+	}
+	s.x1mth2 = 1 - cosio2
+	s.cc4 = 2 * s.no * coef1 * ao * omeosq *
+		(s.eta*(2+0.5*etasq) + s.ecco*(0.5+2*etasq) -
+			j2*tsi/(ao*psisq)*(-3*s.con41*(1-2*eeta+etasq*(1.5-0.5*eeta))+
+				0.75*s.x1mth2*(2*etasq-eeta*(1+etasq))*math.Cos(2*s.argpo)))
+	s.cc5 = 2 * coef1 * ao * omeosq * (1 + 2.75*(etasq+eeta) + eeta*etasq)
+
+	// Secular rates of the mean anomaly, the argument of perigee and the node
+	cosio4 := cosio2 * cosio2
+	temp1 := 1.5 * j2 * pinvsq * s.no
+	temp2 := 0.5 * temp1 * j2 * pinvsq
+	temp3 := -0.46875 * j4 * pinvsq * pinvsq * s.no
+	s.mdot = s.no + 0.5*temp1*rteosq*s.con41 + 0.0625*temp2*rteosq*(13-78*cosio2+137*cosio4)
+	s.argpdot = -0.5*temp1*con42 + 0.0625*temp2*(7-114*cosio2+395*cosio4) +
+		temp3*(3-36*cosio2+49*cosio4)
+	xhdot1 := -temp1 * cosio
+	s.nodedot = xhdot1 + (0.5*temp2*(4-19*cosio2)+2*temp3*(3-7*cosio2))*cosio
+
+	s.omgcof = s.bstar * cc3 * math.Cos(s.argpo)
+	s.xmcof = 0
+	if s.ecco > 1.0e-4 {
+		s.xmcof = -x2o3 * coef * s.bstar / eeta
+	}
+	s.nodecf = 3.5 * omeosq * xhdot1 * s.cc1
+	s.t2cof = 1.5 * s.cc1
+	if math.Abs(cosio+1) > 1.5e-12 {
+		s.xlcof = -0.25 * j3oj2 * sinio * (3 + 5*cosio) / (1 + cosio)
+	} else {
+		s.xlcof = -0.25 * j3oj2 * sinio * (3 + 5*cosio) / 1.5e-12
+	}
+	s.aycof = -0.5 * j3oj2 * sinio
+	s.delmo = math.Pow(1+s.eta*math.Cos(s.mo), 3)
+	s.sinmao = math.Sin(s.mo)
+	s.x7thm1 = 7*cosio2 - 1
+
+	if !s.isimp {
+		cc1sq := s.cc1 * s.cc1
+		s.d2 = 4 * ao * tsi * cc1sq
+		temp := s.d2 * tsi * s.cc1 / 3
+		s.d3 = (17*ao + sfour) * temp
+		s.d4 = 0.5 * temp * ao * tsi * (221*ao + 31*sfour) * s.cc1
+		s.t3cof = s.d2 + 2*cc1sq
+		s.t4cof = 0.25 * (3*s.d3 + s.cc1*(12*s.d2+10*cc1sq))
+		s.t5cof = 0.2 * (3*s.d4 + 12*s.cc1*s.d3 + 6*s.d2*s.d2 + 15*cc1sq*(2*s.d2+cc1sq))
+	}
+}
+
+// Propagate returns the position in m and the velocity in m/s of the
+// satellite at time t in the True Equator Mean Equinox (TEME) frame
+func (s *Satellite) Propagate(t time.Time) (pos, vel geo.Vec3, err error) {
+	tsince := t.Sub(s.TLE.Epoch).Minutes()
+
+	// Secular gravity and atmospheric drag
+	xmdf := s.mo + s.mdot*tsince
+	argpdf := s.argpo + s.argpdot*tsince
+	nodedf := s.nodeo + s.nodedot*tsince
+	argpm := argpdf
+	mm := xmdf
+	t2 := tsince * tsince
+	nodem := nodedf + s.nodecf*t2
+	tempa := 1 - s.cc1*tsince
+	tempe := s.bstar * s.cc4 * tsince
+	templ := s.t2cof * t2
+
+	if !s.isimp {
+		delomg := s.omgcof * tsince
+		delm := s.xmcof * (math.Pow(1+s.eta*math.Cos(xmdf), 3) - s.delmo)
+		temp := delomg + delm
+		mm = xmdf + temp
+		argpm = argpdf - temp
+		t3 := t2 * tsince
+		t4 := t3 * tsince
+		tempa = tempa - s.d2*t2 - s.d3*t3 - s.d4*t4
+		tempe = tempe + s.bstar*s.cc5*(math.Sin(mm)-s.sinmao)
+		templ = templ + s.t3cof*t3 + t4*(s.t4cof+tsince*s.t5cof)
+	}
+
+	nm := s.no
+	em := s.ecco
+	inclm := s.inclo
+	am := math.Pow(xke/nm, x2o3) * tempa * tempa
+	nm = xke / math.Pow(am, 1.5)
+	em = em - tempe
+	if em >= 1 || em < -0.001 {
+		return pos, vel, ErrEccentricity
+	}
+	if em < 1.0e-6 {
+		em = 1.0e-6
+	}
+	mm = mm + s.no*templ
+	xlm := mm + argpm + nodem
+
+	nodem = math.Mod(nodem, twoPi)
+	argpm = math.Mod(argpm, twoPi)
+	xlm = math.Mod(xlm, twoPi)
+	mm = math.Mod(xlm-argpm-nodem, twoPi)
+
+	sinip := math.Sin(inclm)
+	cosip := math.Cos(inclm)
+
+	// Long period periodics
+	axnl := em * math.Cos(argpm)
+	temp := 1 / (am * (1 - em*em))
+	aynl := em*math.Sin(argpm) + temp*s.aycof
+	xl := mm + argpm + nodem + temp*s.xlcof*axnl
+
+	// Solve Kepler's equation
+	u := math.Mod(xl-nodem, twoPi)
+	eo1 := u
+	tem5 := 9999.9
+	var sineo1, coseo1 float64
+	for ktr := 1; math.Abs(tem5) >= 1.0e-12 && ktr <= 10; ktr++ {
+		sineo1 = math.Sin(eo1)
+		coseo1 = math.Cos(eo1)
+		tem5 = 1 - coseo1*axnl - sineo1*aynl
+		tem5 = (u - aynl*coseo1 + axnl*sineo1 - eo1) / tem5
+		if math.Abs(tem5) >= 0.95 {
+			tem5 = math.Copysign(0.95, tem5)
+		}
+		eo1 += tem5
+	}
+
+	// Short period preliminary quantities
+	ecose := axnl*coseo1 + aynl*sineo1
+	esine := axnl*sineo1 - aynl*coseo1
+	el2 := axnl*axnl + aynl*aynl
+	pl := am * (1 - el2)
+	if pl < 0 {
+		return pos, vel, ErrEccentricity
+	}
+	rl := am * (1 - ecose)
+	rdotl := math.Sqrt(am) * esine / rl
+	rvdotl := math.Sqrt(pl) / rl
+	betal := math.Sqrt(1 - el2)
+	temp = esine / (1 + betal)
+	sinu := am / rl * (sineo1 - aynl - axnl*temp)
+	cosu := am / rl * (coseo1 - axnl + aynl*temp)
+	su := math.Atan2(sinu, cosu)
+	sin2u := (cosu + cosu) * sinu
+	cos2u := 1 - 2*sinu*sinu
+	temp = 1 / pl
+	temp1 := 0.5 * j2 * temp
+	temp2 := temp1 * temp
+
+	// Update for short period periodics
+	mrt := rl*(1-1.5*temp2*betal*s.con41) + 0.5*temp1*s.x1mth2*cos2u
+	su = su - 0.25*temp2*s.x7thm1*sin2u
+	xnode := nodem + 1.5*temp2*cosip*sin2u
+	xinc := inclm + 1.5*temp2*cosip*sinip*cos2u
+	mvt := rdotl - nm*temp1*s.x1mth2*sin2u/xke
+	rvdot := rvdotl + nm*temp1*(s.x1mth2*cos2u+1.5*s.con41)/xke
+
+	// Orientation vectors
+	sinsu, cossu := math.Sin(su), math.Cos(su)
+	snod, cnod := math.Sin(xnode), math.Cos(xnode)
+	sini, cosi := math.Sin(xinc), math.Cos(xinc)
+	xmx := -snod * cosi
+	xmy := cnod * cosi
+	ux := geo.Vec3{X: xmx*sinsu + cnod*cossu, Y: xmy*sinsu + snod*cossu, Z: sini * sinsu}
+	vx := geo.Vec3{X: xmx*cossu - cnod*sinsu, Y: xmy*cossu - snod*sinsu, Z: sini * cossu}
+
+	if mrt < 1 {
+		return pos, vel, ErrDecayed
+	}
+	pos = ux.Scale(mrt * radiusEarthKm * 1000)
+	vel = ux.Scale(mvt).Add(vx.Scale(rvdot)).Scale(radiusEarthKm * 1000 * xke / 60)
+	return pos, vel, nil
+}
+
+// Position returns the ECEF position of the satellite at time t, so that a
+// Satellite can be an endpoint of a geo.Positioner based delay model. The
+// polar motion is neglected. If the propagation fails, e.g., because the
+// satellite has decayed, the position is the center of the Earth; use
+// Propagate to get the error.
+func (s *Satellite) Position(t time.Time) geo.Vec3 {
+	pos, _, err := s.Propagate(t)
+	if err != nil {
+		return geo.Vec3{}
+	}
+	return geo.RotateZ(pos, -GMST(t))
+}
+
+// GMST returns the Greenwich mean sidereal time at t in radians, which is the
+// angle between the TEME and the ECEF frames
+func GMST(t time.Time) float64 {
+	jd := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	tut1 := (jd - 2451545.0) / 36525
+	sec := -6.2e-6*tut1*tut1*tut1 + 0.093104*tut1*tut1 +
+		(876600.0*3600+8640184.812866)*tut1 + 67310.54841
+	gmst := math.Mod(sec*deg2rad/240, twoPi)
+	if gmst < 0 {
+		gmst += twoPi
+	}
+	return gmst
+}
@@ -0,0 +1,81 @@
+package orbit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The first case of the SGP4 verification set of Vallado et al., "Revisiting
+// Spacetrack Report #3" (AIAA 2006-6753), propagated with the WGS-72 constants
+const (
+	vallado00005Line1 = "1 00005U 58002B   00179.78495062  .00000023  00000-0  28098-4 0  4753"
+	vallado00005Line2 = "2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667"
+)
+
+func TestParseTLE(t *testing.T) {
+	tle, err := ParseTLE("VANGUARD 1", vallado00005Line1, vallado00005Line2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Date(2000, 6, 27, 18, 50, 19, 733568000, time.UTC)
+	if d := tle.Epoch.Sub(epoch); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("epoch %v, want %v", tle.Epoch, epoch)
+	}
+	if tle.SatNum != 5 || tle.Eccentricity != 0.1859667 || tle.MeanMotion != 10.82419157 ||
+		tle.BStar != 0.28098e-4 || tle.RevNum != 41366 {
+		t.Errorf("parsed %+v", tle)
+	}
+
+	bad := vallado00005Line1[:68] + "0"
+	if _, err := ParseTLE("", bad, vallado00005Line2); err == nil {
+		t.Error("a line with a bad checksum was accepted")
+	}
+}
+
+func TestPropagateVallado(t *testing.T) {
+	tle, err := ParseTLE("", vallado00005Line1, vallado00005Line2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSatellite(tle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DeepSpace {
+		t.Fatal("a 133-minute orbit was taken for a deep-space one")
+	}
+
+	// TEME position in km and velocity in km/s since the epoch
+	tests := []struct {
+		minutes  float64
+		pos, vel [3]float64
+	}{
+		{0, [3]float64{7022.46529266, -1400.08296755, 0.03995155},
+			[3]float64{1.893841015, 6.405893759, 4.534807250}},
+		{360, [3]float64{-7154.03120202, -3783.17682504, -3536.19412294},
+			[3]float64{4.741887409, -4.151817765, -2.093935425}},
+	}
+	const posTol, velTol = 1.0, 1e-3 // m and m/s
+	for _, tt := range tests {
+		at := tle.Epoch.Add(time.Duration(tt.minutes * float64(time.Minute)))
+		pos, vel, err := s.Propagate(at)
+		if err != nil {
+			t.Fatalf("%v min: %v", tt.minutes, err)
+		}
+		gotPos := [3]float64{pos.X, pos.Y, pos.Z}
+		gotVel := [3]float64{vel.X, vel.Y, vel.Z}
+		for i := range gotPos {
+			if d := math.Abs(gotPos[i] - 1000*tt.pos[i]); d > posTol {
+				t.Errorf("%v min: position %v m, want %v km (off by %.3f m)", tt.minutes, gotPos, tt.pos, d)
+				break
+			}
+		}
+		for i := range gotVel {
+			if d := math.Abs(gotVel[i] - 1000*tt.vel[i]); d > velTol {
+				t.Errorf("%v min: velocity %v m/s, want %v km/s (off by %.6f m/s)", tt.minutes, gotVel, tt.vel, d)
+				break
+			}
+		}
+	}
+}
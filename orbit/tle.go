@@ -0,0 +1,190 @@
+// Package orbit parses Two-Line Element sets and propagates the satellites
+// they describe with the SGP4 model, so that the positions of LEO and GEO
+// satellites can drive the delay and visibility of the links.
+package orbit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLE is a Two-Line Element set. Angles are in degrees and the mean motion is
+// in revolutions per day, as in the element set.
+type TLE struct {
+	Name         string
+	SatNum       int
+	Epoch        time.Time
+	NDot         float64 // First derivative of the mean motion in rev/day^2 / 2
+	NDDot        float64 // Second derivative of the mean motion in rev/day^3 / 6
+	BStar        float64 // Drag term in 1/earth radii
+	Inclination  float64
+	RAAN         float64
+	Eccentricity float64
+	ArgPerigee   float64
+	MeanAnomaly  float64
+	MeanMotion   float64
+	RevNum       int
+}
+
+// ParseTLE parses the two lines of an element set and verifies their
+// checksums. name may be empty.
+func ParseTLE(name, line1, line2 string) (*TLE, error) {
+	line1 = strings.TrimRight(line1, " \r\n")
+	line2 = strings.TrimRight(line2, " \r\n")
+	if len(line1) < 69 || len(line2) < 69 {
+		return nil, errors.New("tle: lines must be 69 characters long")
+	}
+	if line1[0] != '1' || line2[0] != '2' {
+		return nil, errors.New("tle: lines must start with 1 and 2")
+	}
+	for _, l := range []string{line1, line2} {
+		if checksum(l[:68]) != int(l[68]-'0') {
+			return nil, fmt.Errorf("tle: bad checksum in line %c", l[0])
+		}
+	}
+
+	t := &TLE{Name: strings.TrimSpace(name)}
+	p := fieldParser{}
+	t.SatNum = p.int(line1[2:7])
+	year := p.int(line1[18:20])
+	day := p.float(line1[20:32])
+	t.NDot = p.float(line1[33:43])
+	t.NDDot = p.exp(line1[44:52])
+	t.BStar = p.exp(line1[53:61])
+
+	if p.int(line2[2:7]) != t.SatNum && p.err == nil {
+		return nil, errors.New("tle: satellite numbers of the lines differ")
+	}
+	t.Inclination = p.float(line2[8:16])
+	t.RAAN = p.float(line2[17:25])
+	t.Eccentricity = p.float("0." + strings.TrimSpace(line2[26:33]))
+	t.ArgPerigee = p.float(line2[34:42])
+	t.MeanAnomaly = p.float(line2[43:51])
+	t.MeanMotion = p.float(line2[52:63])
+	t.RevNum = p.int(line2[63:68])
+	if p.err != nil {
+		return nil, fmt.Errorf("tle: %v", p.err)
+	}
+
+	if year < 57 {
+		year += 2000
+	} else {
+		year += 1900
+	}
+	t.Epoch = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).
+		Add(time.Duration((day - 1) * 24 * float64(time.Hour)))
+	return t, nil
+}
+
+// ReadTLEs reads all the element sets in r, either in the two-line format or
+// in the three-line format with a name line before each set
+func ReadTLEs(r io.Reader) ([]*TLE, error) {
+	var tles []*TLE
+	var name string
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), " \r")
+		switch {
+		case l == "":
+			continue
+		case strings.HasPrefix(l, "1 ") && len(lines) == 0:
+			lines = append(lines, l)
+		case strings.HasPrefix(l, "2 ") && len(lines) == 1:
+			t, err := ParseTLE(name, lines[0], l)
+			if err != nil {
+				return nil, err
+			}
+			tles = append(tles, t)
+			name, lines = "", nil
+		default:
+			if len(lines) != 0 {
+				return nil, fmt.Errorf("tle: unexpected line %q", l)
+			}
+			name = strings.TrimPrefix(l, "0 ")
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) != 0 {
+		return nil, errors.New("tle: missing second line")
+	}
+	return tles, nil
+}
+
+// LoadTLEs reads all the element sets in a file
+func LoadTLEs(path string) ([]*TLE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTLEs(f)
+}
+
+// checksum returns the modulo 10 sum of the digits of a line, where each minus
+// sign counts as 1
+func checksum(l string) int {
+	sum := 0
+	for _, c := range l {
+		switch {
+		case c >= '0' && c <= '9':
+			sum += int(c - '0')
+		case c == '-':
+			sum++
+		}
+	}
+	return sum % 10
+}
+
+// fieldParser parses the fixed-width fields of a TLE and keeps the first error
+type fieldParser struct {
+	err error
+}
+
+func (p *fieldParser) int(s string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return v
+}
+
+func (p *fieldParser) float(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return v
+}
+
+// exp parses a field with an implied leading decimal point and an exponent,
+// e.g., " 12345-3" is 0.12345e-3
+func (p *fieldParser) exp(s string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	sign := 1.0
+	if s[0] == '-' || s[0] == '+' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	i := strings.LastIndexAny(s, "+-")
+	if i <= 0 {
+		return sign * p.float("0."+s)
+	}
+	mantissa := p.float("0." + s[:i])
+	exponent := p.int(s[i:])
+	return sign * mantissa * math.Pow(10, float64(exponent))
+}
@@ -0,0 +1,82 @@
+package mpthSim
+
+import "time"
+
+// QueueStats summarizes the occupancy of the transmission queue of a Link up
+// to the last packet that entered it. The occupancy counts the packets waiting
+// for the transmitter, not the one being serialized.
+type QueueStats struct {
+	// Mean is the time-averaged number of waiting packets
+	Mean float64
+	// Max is the largest number of waiting packets
+	Max int
+	// Busy is the fraction of time the transmitter was serializing packets
+	Busy float64
+}
+
+// linkQueue keeps the times at which the packets in a capacity-limited link
+// finish their serialization. Since packets are served in FIFO order and the
+// serialization times are known on arrival, the queue is simulated
+// analytically when packets arrive instead of by a transmitter goroutine.
+type linkQueue struct {
+	departures []time.Time
+	start      time.Time
+	last       time.Time
+	area       float64 // Integral of the waiting packets over time in seconds
+	busy       time.Duration
+	max        int
+}
+
+// waiting returns the number of packets waiting for the transmitter
+func (q *linkQueue) waiting() int {
+	if len(q.departures) == 0 {
+		return 0
+	}
+	return len(q.departures) - 1
+}
+
+// advance removes the packets whose serialization ended before now and
+// integrates the occupancy up to now
+func (q *linkQueue) advance(now time.Time) {
+	if q.start.IsZero() {
+		q.start, q.last = now, now
+	}
+	for len(q.departures) > 0 && !q.departures[0].After(now) {
+		q.integrate(q.departures[0])
+		q.departures = q.departures[1:]
+	}
+	q.integrate(now)
+}
+
+func (q *linkQueue) integrate(t time.Time) {
+	dt := t.Sub(q.last)
+	q.area += float64(q.waiting()) * dt.Seconds()
+	if len(q.departures) > 0 {
+		q.busy += dt
+	}
+	q.last = t
+}
+
+// push queues a packet arriving at now that takes serialization to be
+// transmitted and returns the time at which its serialization ends
+func (q *linkQueue) push(now time.Time, serialization time.Duration) time.Time {
+	begin := now
+	if n := len(q.departures); n > 0 {
+		begin = q.departures[n-1]
+	}
+	end := begin.Add(serialization)
+	q.departures = append(q.departures, end)
+	if q.waiting() > q.max {
+		q.max = q.waiting()
+	}
+	return end
+}
+
+func (q *linkQueue) stats() QueueStats {
+	s := QueueStats{Max: q.max}
+	if elapsed := q.last.Sub(q.start); elapsed > 0 {
+		s.Mean = q.area / elapsed.Seconds()
+		s.Busy = q.busy.Seconds() / elapsed.Seconds()
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package rlnc
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Encoding is the way the payloads carry their coding coefficients
+type Encoding uint8
+
+const (
+	// FullVector payloads carry the whole packed coefficient vector, which is
+	// the default
+	FullVector Encoding = iota
+	// Sparse payloads carry the indices of the non-zero coefficients and
+	// their values, or the whole vector when that is shorter
+	Sparse
+	// Seed payloads carry the seed from which the decoder draws the
+	// coefficients again, with the density of a sparse encoder. Only
+	// encoders can, so the recoded payloads are Sparse.
+	Seed
+)
+
+// Formats of the payloads of the Sparse and Seed encodings, given by their
+// first byte
+const (
+	vectorFormat = iota // The packed coefficient vector
+	sparseFormat        // The number of non-zero coefficients, their indices and values
+	seedFormat          // The seed and the density
+)
+
+// seedSize is the size of the seed and the density of a seed payload
+const seedSize = 4 + 4
+
+// coefficientsBound returns the largest size of the coefficients of a payload
+// with the given encoding, in bytes
+func coefficientsBound(f Field, n int, e Encoding) int {
+	size := f.CoefficientsSize(n)
+	if e == FullVector {
+		return size
+	}
+	if e == Seed && seedSize > size {
+		size = seedSize
+	}
+	return 1 + size
+}
+
+// putCoefficients writes coeffs at the beginning of payload with the given
+// encoding, and returns the bytes written. Sparse and Seed write the shorter
+// of the vector and sparse formats.
+func putCoefficients(payload []byte, f Field, coeffs []uint32, e Encoding) int {
+	if e == FullVector {
+		for i, c := range coeffs {
+			f.SetCoefficient(payload, i, c)
+		}
+		return f.CoefficientsSize(len(coeffs))
+	}
+	var nonZero []int
+	for i, c := range coeffs {
+		if c != 0 {
+			nonZero = append(nonZero, i)
+		}
+	}
+	if 2+2*len(nonZero)+f.CoefficientsSize(len(nonZero)) >= f.CoefficientsSize(len(coeffs)) {
+		payload[0] = vectorFormat
+		return 1 + putCoefficients(payload[1:], f, coeffs, FullVector)
+	}
+	payload[0] = sparseFormat
+	putIndex(payload[1:], len(nonZero))
+	values := payload[3+2*len(nonZero):]
+	for k, i := range nonZero {
+		putIndex(payload[3+2*k:], i)
+		f.SetCoefficient(values, k, coeffs[i])
+	}
+	return 3 + 2*len(nonZero) + f.CoefficientsSize(len(nonZero))
+}
+
+// putSeed writes a seed payload header with the given seed and density, and
+// returns the bytes written
+func putSeed(payload []byte, seed uint32, density float32) int {
+	payload[0] = seedFormat
+	putUint32(payload[1:], seed)
+	putUint32(payload[5:], math.Float32bits(density))
+	return 1 + seedSize
+}
+
+// readCoefficients reads the n coefficients at the beginning of payload
+// written with the given encoding, and returns them with the bytes they took
+func readCoefficients(payload []byte, f Field, n int, e Encoding) ([]uint32, int) {
+	coeffs := make([]uint32, n)
+	if e == FullVector {
+		for i := range coeffs {
+			coeffs[i] = f.Coefficient(payload, i)
+		}
+		return coeffs, f.CoefficientsSize(n)
+	}
+	switch payload[0] {
+	case sparseFormat:
+		count := index(payload[1:])
+		values := payload[3+2*count:]
+		for k := 0; k < count; k++ {
+			i := index(payload[3+2*k:])
+			coeffs[i] = f.Coefficient(values, k)
+		}
+		return coeffs, 3 + 2*count + f.CoefficientsSize(count)
+	case seedFormat:
+		seed := getUint32(payload[1:])
+		density := math.Float32frombits(getUint32(payload[5:]))
+		return seedCoefficients(f, seed, n, density), 1 + seedSize
+	}
+	coeffs, size := readCoefficients(payload[1:], f, n, FullVector)
+	return coeffs, 1 + size
+}
+
+// seedCoefficients draws the n coefficients of a seed payload, uniform ones
+// for a zero density and sparse ones otherwise
+func seedCoefficients(f Field, seed uint32, n int, density float32) []uint32 {
+	rng := rand.New(rand.NewSource(int64(seed)))
+	if density > 0 {
+		return sparseCoefficients(f, rng, n, float64(density))
+	}
+	return randomCoefficients(f, rng, n)
+}
+
+// putIndex writes the index of a symbol in two bytes, in big-endian order
+func putIndex(payload []byte, i int) {
+	payload[0] = byte(i >> 8)
+	payload[1] = byte(i)
+}
+
+// index reads an index written by putIndex
+func index(payload []byte) int {
+	return int(payload[0])<<8 | int(payload[1])
+}
+
+// putUint32 writes v in four bytes, in big-endian order
+func putUint32(payload []byte, v uint32) {
+	payload[0] = byte(v >> 24)
+	payload[1] = byte(v >> 16)
+	payload[2] = byte(v >> 8)
+	payload[3] = byte(v)
+}
+
+// getUint32 reads a value written by putUint32
+func getUint32(payload []byte) uint32 {
+	return uint32(payload[0])<<24 | uint32(payload[1])<<16 | uint32(payload[2])<<8 | uint32(payload[3])
+}
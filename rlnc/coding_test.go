@@ -0,0 +1,130 @@
+package rlnc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// payloadCoder writes coded payloads
+type payloadCoder interface {
+	WritePayload(payload []byte) uint32
+	PayloadSize() uint32
+}
+
+// transfer sends payloads from the encoder through the recoder to the
+// decoder, truncated to the bytes written, until the decoder is complete. The
+// recoder only gets every other payload of the encoder, and the decoder gets
+// both, so the recoded payloads are needed. It fails after too many payloads.
+func transfer(t *testing.T, name string, enc payloadCoder, rec, dec *Decoder) {
+	t.Helper()
+	limit := 20 * int(dec.Symbols())
+	for i := 0; !dec.IsComplete(); i++ {
+		if i == limit {
+			t.Fatalf("%s: rank %d of %d after %d payloads", name, dec.Rank(), dec.Symbols(), limit)
+		}
+		payload := make([]byte, enc.PayloadSize())
+		payload = payload[:enc.WritePayload(payload)]
+		if i%2 == 0 {
+			rec.ReadPayload(payload)
+		}
+		dec.ReadPayload(payload)
+		if rec.Rank() > 0 {
+			recoded := make([]byte, rec.PayloadSize())
+			recoded = recoded[:rec.WritePayload(recoded)]
+			dec.ReadPayload(recoded)
+		}
+	}
+}
+
+func TestEncodeRecodeDecode(t *testing.T) {
+	const symbols, symbolSize = 16, 32
+	variants := []struct {
+		name     string
+		encoder  func(f Field) *Encoder
+		encoding Encoding
+	}{
+		{"full_vector", func(f Field) *Encoder { return NewEncoder(f, symbols, symbolSize) }, FullVector},
+		{"sparse", func(f Field) *Encoder { return NewSparseEncoder(f, symbols, symbolSize, 0.3) }, Sparse},
+		{"seed", func(f Field) *Encoder { return NewEncoder(f, symbols, symbolSize) }, Seed},
+		{"sparse_seed", func(f Field) *Encoder { return NewSparseEncoder(f, symbols, symbolSize, 0.3) }, Seed},
+		{"perpetual", func(f Field) *Encoder { return NewPerpetualEncoder(f, symbols, symbolSize, 4) }, Sparse},
+	}
+	rand.Seed(5)
+	for _, fl := range fields {
+		for _, v := range variants {
+			name := fl.f.Name() + "/" + v.name
+			data := make([]byte, symbols*symbolSize)
+			rand.Read(data)
+
+			enc := v.encoder(fl.f)
+			enc.SetEncoding(v.encoding)
+			enc.SetConstSymbols(data)
+			rec := NewDecoder(fl.f, symbols, symbolSize)
+			rec.SetEncoding(v.encoding)
+			dec := NewDecoder(fl.f, symbols, symbolSize)
+			dec.SetEncoding(v.encoding)
+			decoded := make([]byte, len(data))
+			dec.SetMutableSymbols(decoded)
+
+			transfer(t, name, enc, rec, dec)
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s: decoded data differ", name)
+			}
+		}
+	}
+}
+
+func TestSystematic(t *testing.T) {
+	const symbols, symbolSize = 8, 10
+	data := make([]byte, symbols*symbolSize)
+	rand.Read(data)
+	enc := NewEncoder(Binary8, symbols, symbolSize)
+	enc.SetConstSymbols(data)
+	enc.SetSystematicOn()
+	dec := NewDecoder(Binary8, symbols, symbolSize)
+	for i := 0; i < symbols; i++ {
+		if !enc.InSystematicPhase() {
+			t.Fatalf("left the systematic phase after %d payloads", i)
+		}
+		payload := make([]byte, enc.PayloadSize())
+		dec.ReadPayload(payload[:enc.WritePayload(payload)])
+		if !dec.IsSymbolDecoded(i) {
+			t.Fatalf("symbol %d not decoded from its uncoded payload", i)
+		}
+	}
+	if enc.InSystematicPhase() || !dec.IsComplete() {
+		t.Error("the systematic phase did not decode the block")
+	}
+}
+
+func TestSparseShorterThanVector(t *testing.T) {
+	// A payload with a single non-zero coefficient is shorter in the sparse
+	// format, and a dense one keeps the vector format
+	const n = 100
+	coeffs := make([]uint32, n)
+	coeffs[42] = 7
+	payload := make([]byte, coefficientsBound(Binary8, n, Sparse))
+	if size := putCoefficients(payload, Binary8, coeffs, Sparse); size >= Binary8.CoefficientsSize(n) {
+		t.Errorf("sparse coefficients take %d bytes, the vector %d", size, Binary8.CoefficientsSize(n))
+	}
+	got, _ := readCoefficients(payload, Binary8, n, Sparse)
+	for i := range coeffs {
+		if got[i] != coeffs[i] {
+			t.Fatalf("coefficient %d read as %d, want %d", i, got[i], coeffs[i])
+		}
+	}
+
+	for i := range coeffs {
+		coeffs[i] = uint32(1 + i%255)
+	}
+	if size := putCoefficients(payload, Binary8, coeffs, Sparse); size != 1+Binary8.CoefficientsSize(n) {
+		t.Errorf("dense coefficients take %d bytes, want the vector and its format byte", size)
+	}
+	got, _ = readCoefficients(payload, Binary8, n, Sparse)
+	for i := range coeffs {
+		if got[i] != coeffs[i] {
+			t.Fatalf("coefficient %d read as %d, want %d", i, got[i], coeffs[i])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package rlnc
+
+import (
+	"math/rand"
+)
+
+// Decoder recovers a block from coded payloads with on-the-fly Gaussian
+// elimination. The received combinations are kept in reduced row echelon
+// form, so the i-th symbol of the block is decoded as soon as the row with
+// its pivot is a unit vector, and the whole block once the rank is full. A
+// decoder can also recode, i.e., write new random combinations of the
+// combinations it holds.
+type Decoder struct {
+	field      Field
+	symbols    int
+	symbolSize int
+	data       []byte
+	rows       [][]uint32 // Coefficients of the combination with pivot i
+	rank       int
+	rng        *rand.Rand
+	encoding   Encoding
+}
+
+// NewDecoder creates a decoder for blocks of the given number of symbols of
+// symbolSize bytes over the field
+func NewDecoder(field Field, symbols, symbolSize uint32) *Decoder {
+	d := &Decoder{
+		field:      field,
+		symbols:    int(symbols),
+		symbolSize: int(symbolSize),
+		rows:       make([][]uint32, symbols),
+		rng:        rand.New(rand.NewSource(rand.Int63())),
+	}
+	d.data = make([]byte, d.BlockSize())
+	return d
+}
+
+// SetMutableSymbols sets the buffer, BlockSize bytes long, where the block is
+// decoded. It must be called before the first payload is read.
+func (d *Decoder) SetMutableSymbols(data []byte) {
+	d.data = data[:d.BlockSize()]
+}
+
+// SetEncoding sets how the payloads carry their coefficients, see
+// Encoder.SetEncoding. Recoded payloads are Sparse with either Sparse or Seed.
+func (d *Decoder) SetEncoding(encoding Encoding) { d.encoding = encoding }
+
+// ReadPayload reads a coded payload written by an encoder or a recoder with
+// the same parameters. Payloads that are not innovative are discarded.
+func (d *Decoder) ReadPayload(payload []byte) {
+	f := d.field
+	coeffs, cs := readCoefficients(payload, f, d.symbols, d.encoding)
+	symbol := make([]byte, d.symbolSize)
+	copy(symbol, payload[cs:cs+d.symbolSize])
+
+	// Subtract the known combinations. The rows are fully reduced, so each
+	// pivot is only visited once.
+	for j, row := range d.rows {
+		if row == nil || coeffs[j] == 0 {
+			continue
+		}
+		c := coeffs[j]
+		for k := j; k < d.symbols; k++ {
+			coeffs[k] ^= f.Mul(c, row[k])
+		}
+		f.MulAdd(symbol, d.symbol(j), c)
+	}
+
+	pivot := -1
+	for k, c := range coeffs {
+		if c != 0 {
+			pivot = k
+			break
+		}
+	}
+	if pivot < 0 {
+		return // Not innovative
+	}
+
+	// Normalize the new row and remove its pivot from the others
+	inv := f.Inv(coeffs[pivot])
+	for k := pivot; k < d.symbols; k++ {
+		coeffs[k] = f.Mul(inv, coeffs[k])
+	}
+	f.Scale(symbol, inv)
+	for i, row := range d.rows {
+		if row == nil || row[pivot] == 0 {
+			continue
+		}
+		c := row[pivot]
+		for k := pivot; k < d.symbols; k++ {
+			row[k] ^= f.Mul(c, coeffs[k])
+		}
+		f.MulAdd(d.symbol(i), symbol, c)
+	}
+
+	d.rows[pivot] = coeffs
+	copy(d.symbol(pivot), symbol)
+	d.rank++
+}
+
+// WritePayload writes a recoded payload, a random combination of the
+// received combinations, to the beginning of payload, which must hold at
+// least PayloadSize bytes, and returns the bytes written
+func (d *Decoder) WritePayload(payload []byte) uint32 {
+	f := d.field
+	payload = payload[:d.PayloadSize()]
+	for i := range payload {
+		payload[i] = 0
+	}
+	coeffs := make([]uint32, d.symbols)
+	symbol := make([]byte, d.symbolSize)
+	for i, c := range randomCoefficients(f, d.rng, d.symbols) {
+		row := d.rows[i]
+		if row == nil || c == 0 {
+			continue
+		}
+		for k := i; k < d.symbols; k++ {
+			coeffs[k] ^= f.Mul(c, row[k])
+		}
+		f.MulAdd(symbol, d.symbol(i), c)
+	}
+	encoding := d.encoding
+	if encoding == Seed {
+		encoding = Sparse
+	}
+	cs := putCoefficients(payload, f, coeffs, encoding)
+	copy(payload[cs:], symbol)
+	return uint32(cs + d.symbolSize)
+}
+
+// PayloadSize returns the largest size of the coded payloads, which is the
+// size of all of them with the FullVector encoding
+func (d *Decoder) PayloadSize() uint32 {
+	return uint32(coefficientsBound(d.field, d.symbols, d.encoding) + d.symbolSize)
+}
+
+// Rank returns the number of linearly independent combinations received
+func (d *Decoder) Rank() uint32 { return uint32(d.rank) }
+
+// IsComplete reports whether the whole block is decoded
+func (d *Decoder) IsComplete() bool { return d.rank == d.symbols }
+
+// IsSymbolDecoded reports whether the i-th symbol of the block is decoded,
+// even if the rest of the block is not
+func (d *Decoder) IsSymbolDecoded(i int) bool {
+	row := d.rows[i]
+	if row == nil {
+		return false
+	}
+	for k := i + 1; k < d.symbols; k++ {
+		if row[k] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Symbols returns the number of symbols of a block
+func (d *Decoder) Symbols() uint32 { return uint32(d.symbols) }
+
+// SymbolSize returns the size of a symbol in bytes
+func (d *Decoder) SymbolSize() uint32 { return uint32(d.symbolSize) }
+
+// BlockSize returns the size of a block in bytes
+func (d *Decoder) BlockSize() uint32 { return uint32(d.symbols * d.symbolSize) }
+
+// symbol returns the i-th symbol of the block
+func (d *Decoder) symbol(i int) []byte {
+	return d.data[i*d.symbolSize : (i+1)*d.symbolSize]
+}
@@ -0,0 +1,209 @@
+package rlnc
+
+import (
+	"math/rand"
+)
+
+// Encoder produces random linear combinations of the symbols of a block.
+// Every payload holds the packed coding coefficients followed by the coded
+// symbol. In systematic mode, the first payloads carry the symbols of the
+// block uncoded, one after another, and only then coded repair symbols.
+//
+// The coefficients of a full vector encoder are drawn uniformly from the
+// field. A sparse encoder makes each of them non-zero with a given density, and
+// a perpetual encoder only draws the width coefficients that follow a random
+// pivot, wrapping around the block, with a unit coefficient at the pivot.
+// Either way the payloads are decoded and recoded by the same Decoder, and
+// carry their coefficients with the Encoding of the encoder.
+type Encoder struct {
+	field      Field
+	symbols    int
+	symbolSize int
+	data       []byte
+	rng        *rand.Rand
+	density    float64 // Density of a sparse encoder, or 0
+	width      int     // Width of a perpetual encoder, or 0
+	encoding   Encoding
+
+	systematic bool
+	next       int // Next symbol sent uncoded in systematic mode
+}
+
+// NewEncoder creates an encoder for blocks of the given number of symbols of
+// symbolSize bytes over the field
+func NewEncoder(field Field, symbols, symbolSize uint32) *Encoder {
+	return &Encoder{
+		field:      field,
+		symbols:    int(symbols),
+		symbolSize: int(symbolSize),
+		rng:        rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// NewSparseEncoder creates an encoder whose coefficients are non-zero with
+// probability density, which must be in (0, 1]
+func NewSparseEncoder(field Field, symbols, symbolSize uint32, density float64) *Encoder {
+	e := NewEncoder(field, symbols, symbolSize)
+	e.density = density
+	return e
+}
+
+// NewPerpetualEncoder creates an encoder whose coefficients are non-zero only
+// at a random pivot and the width symbols after it, wrapping around the block
+func NewPerpetualEncoder(field Field, symbols, symbolSize, width uint32) *Encoder {
+	e := NewEncoder(field, symbols, symbolSize)
+	e.width = int(width)
+	return e
+}
+
+// SetConstSymbols sets the block to encode, which must be BlockSize bytes
+// long. The encoder keeps a reference to it.
+func (e *Encoder) SetConstSymbols(data []byte) {
+	e.data = data[:e.BlockSize()]
+}
+
+// SetSystematicOn makes the encoder send the symbols uncoded before any coded
+// payload
+func (e *Encoder) SetSystematicOn() { e.systematic = true }
+
+// SetSystematicOff makes every payload a random combination, which is the
+// default
+func (e *Encoder) SetSystematicOff() { e.systematic = false }
+
+// IsSystematicOn reports whether the encoder is in systematic mode
+func (e *Encoder) IsSystematicOn() bool { return e.systematic }
+
+// InSystematicPhase reports whether the next payload carries an uncoded symbol
+func (e *Encoder) InSystematicPhase() bool {
+	return e.systematic && e.next < e.symbols && e.data != nil
+}
+
+// WritePayload writes a coded payload to the beginning of payload, which must
+// hold at least PayloadSize bytes, and returns the bytes written. In the
+// systematic phase, the payload is the next symbol with a unit coefficient
+// vector.
+func (e *Encoder) WritePayload(payload []byte) uint32 {
+	payload = payload[:e.PayloadSize()]
+	for i := range payload {
+		payload[i] = 0
+	}
+	var coeffs []uint32
+	var cs int
+	switch {
+	case e.InSystematicPhase():
+		coeffs = make([]uint32, e.symbols)
+		coeffs[e.next] = 1
+		e.next++
+	case e.encoding == Seed && e.width == 0:
+		seed := uint32(e.rng.Int63())
+		coeffs = seedCoefficients(e.field, seed, e.symbols, float32(e.density))
+		cs = putSeed(payload, seed, float32(e.density))
+	default:
+		coeffs = e.coefficients()
+	}
+	if cs == 0 {
+		cs = putCoefficients(payload, e.field, coeffs, e.encoding)
+	}
+	symbol := payload[cs : cs+e.symbolSize]
+	for i, c := range coeffs {
+		e.field.MulAdd(symbol, e.symbol(i), c)
+	}
+	return uint32(cs + e.symbolSize)
+}
+
+// SetEncoding sets how the payloads carry their coefficients. The decoders
+// must use the same encoding, except that any of them reads both Sparse and
+// Seed payloads. A perpetual encoder writes Sparse payloads instead of Seed
+// ones.
+func (e *Encoder) SetEncoding(encoding Encoding) { e.encoding = encoding }
+
+// PayloadSize returns the largest size of the coded payloads, which is the
+// size of all of them with the FullVector encoding
+func (e *Encoder) PayloadSize() uint32 {
+	return uint32(coefficientsBound(e.field, e.symbols, e.encoding) + e.symbolSize)
+}
+
+// Rank returns the number of symbols available to encode, which is either
+// zero or all of them once the block is set
+func (e *Encoder) Rank() uint32 {
+	if e.data == nil {
+		return 0
+	}
+	return uint32(e.symbols)
+}
+
+// Symbols returns the number of symbols of a block
+func (e *Encoder) Symbols() uint32 { return uint32(e.symbols) }
+
+// SymbolSize returns the size of a symbol in bytes
+func (e *Encoder) SymbolSize() uint32 { return uint32(e.symbolSize) }
+
+// BlockSize returns the size of a block in bytes
+func (e *Encoder) BlockSize() uint32 { return uint32(e.symbols * e.symbolSize) }
+
+// symbol returns the i-th symbol of the block
+func (e *Encoder) symbol(i int) []byte {
+	return e.data[i*e.symbolSize : (i+1)*e.symbolSize]
+}
+
+// coefficients draws the coefficients of a coded payload as the variant of the
+// encoder does
+func (e *Encoder) coefficients() []uint32 {
+	switch {
+	case e.density > 0:
+		return sparseCoefficients(e.field, e.rng, e.symbols, e.density)
+	case e.width > 0:
+		return perpetualCoefficients(e.field, e.rng, e.symbols, e.width)
+	}
+	return randomCoefficients(e.field, e.rng, e.symbols)
+}
+
+// sparseCoefficients draws n coefficients that are non-zero with probability
+// density, and then uniform among the non-zero elements, not all of them zero
+func sparseCoefficients(f Field, rng *rand.Rand, n int, density float64) []uint32 {
+	coeffs := make([]uint32, n)
+	for {
+		zero := true
+		for i := range coeffs {
+			coeffs[i] = 0
+			if rng.Float64() < density {
+				coeffs[i] = 1 + uint32(rng.Int63n(int64(f.Order()-1)))
+				zero = false
+			}
+		}
+		if !zero || n == 0 {
+			return coeffs
+		}
+	}
+}
+
+// perpetualCoefficients draws n coefficients with a one at a random pivot, the
+// width ones that follow it, wrapping around, uniform, and the others zero
+func perpetualCoefficients(f Field, rng *rand.Rand, n, width int) []uint32 {
+	coeffs := make([]uint32, n)
+	if n == 0 {
+		return coeffs
+	}
+	pivot := rng.Intn(n)
+	coeffs[pivot] = 1
+	for k := 1; k <= width && k < n; k++ {
+		coeffs[(pivot+k)%n] = uint32(rng.Int63n(int64(f.Order())))
+	}
+	return coeffs
+}
+
+// randomCoefficients draws n coefficients uniformly from the field, not all
+// of them zero
+func randomCoefficients(f Field, rng *rand.Rand, n int) []uint32 {
+	coeffs := make([]uint32, n)
+	for {
+		zero := true
+		for i := range coeffs {
+			coeffs[i] = uint32(rng.Int63n(int64(f.Order())))
+			zero = zero && coeffs[i] == 0
+		}
+		if !zero || n == 0 {
+			return coeffs
+		}
+	}
+}
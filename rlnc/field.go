@@ -0,0 +1,321 @@
+// Package rlnc implements random linear network coding in pure Go. A block of
+// data is split into symbols of equal size, and every coded payload carries a
+// random linear combination of them together with its coding coefficients.
+// Decoders recover the block with on-the-fly Gaussian elimination and can
+// recode, i.e., produce new combinations of what they have received before
+// decoding.
+package rlnc
+
+// Field is a finite field of characteristic two used for the coding
+// coefficients. The symbols are vectors of field elements packed in bytes, so
+// addition and subtraction are XOR.
+type Field interface {
+	// Name returns the name of the field, e.g., "binary8"
+	Name() string
+	// Order returns the number of elements of the field
+	Order() uint32
+	// Mul returns a*b
+	Mul(a, b uint32) uint32
+	// Inv returns the multiplicative inverse of a, which must not be zero
+	Inv(a uint32) uint32
+	// MulAdd sets dst to dst + c*src element-wise
+	MulAdd(dst, src []byte, c uint32)
+	// Scale sets dst to c*dst element-wise
+	Scale(dst []byte, c uint32)
+	// CoefficientsSize returns the bytes taken by n packed coefficients
+	CoefficientsSize(n int) int
+	// Coefficient returns the i-th coefficient packed in buf
+	Coefficient(buf []byte, i int) uint32
+	// SetCoefficient sets the i-th coefficient packed in buf to c
+	SetCoefficient(buf []byte, i int, c uint32)
+}
+
+// Binary is GF(2). Every bit of a symbol is an element, and the coefficients
+// are packed eight per byte.
+var Binary Field = binary{}
+
+// Binary4 is GF(2^4) with the polynomial x^4+x+1. Every byte of a symbol holds
+// two elements, and the coefficients are packed two per byte.
+var Binary4 Field = newBinary4()
+
+// Binary8 is GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1. Every byte of a
+// symbol is an element.
+var Binary8 Field = newBinary8()
+
+// Binary16 is GF(2^16) with the polynomial x^16+x^12+x^3+x+1. Every two bytes
+// of a symbol are an element in big-endian order, so the symbols must have an
+// even size.
+var Binary16 Field = newBinary16()
+
+// FieldByName returns the field with the given name, or nil if there is none
+func FieldByName(name string) Field {
+	for _, f := range []Field{Binary, Binary4, Binary8, Binary16} {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+type binary struct{}
+
+func (binary) Name() string { return "binary" }
+
+func (binary) Order() uint32 { return 2 }
+
+func (binary) Mul(a, b uint32) uint32 { return a & b }
+
+func (binary) Inv(a uint32) uint32 { return a }
+
+func (binary) MulAdd(dst, src []byte, c uint32) {
+	if c == 0 {
+		return
+	}
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func (binary) Scale(dst []byte, c uint32) {
+	if c != 0 {
+		return
+	}
+	for i := range dst {
+		dst[i] = 0
+	}
+}
+
+func (binary) CoefficientsSize(n int) int { return (n + 7) / 8 }
+
+func (binary) Coefficient(buf []byte, i int) uint32 {
+	return uint32(buf[i/8]>>uint(i%8)) & 1
+}
+
+func (binary) SetCoefficient(buf []byte, i int, c uint32) {
+	buf[i/8] &^= 1 << uint(i%8)
+	buf[i/8] |= byte(c&1) << uint(i%8)
+}
+
+// binary4 multiplies both elements of a byte with a single lookup in a table
+// of the products of every element with every byte
+type binary4 struct {
+	exp [30]byte
+	log [16]int
+	mul [16][256]byte
+}
+
+func newBinary4() *binary4 {
+	f := new(binary4)
+	x := 1
+	for i := 0; i < 15; i++ {
+		f.exp[i] = byte(x)
+		f.exp[i+15] = byte(x)
+		f.log[x] = i
+		x <<= 1
+		if x&0x10 != 0 {
+			x ^= 0x13
+		}
+	}
+	for c := uint32(1); c < 16; c++ {
+		for b := uint32(0); b < 256; b++ {
+			f.mul[c][b] = byte(f.Mul(c, b>>4)<<4 | f.Mul(c, b&0xf))
+		}
+	}
+	return f
+}
+
+func (f *binary4) Name() string { return "binary4" }
+
+func (f *binary4) Order() uint32 { return 16 }
+
+func (f *binary4) Mul(a, b uint32) uint32 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return uint32(f.exp[f.log[a]+f.log[b]])
+}
+
+func (f *binary4) Inv(a uint32) uint32 { return uint32(f.exp[15-f.log[a]]) }
+
+func (f *binary4) MulAdd(dst, src []byte, c uint32) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+		return
+	}
+	row := &f.mul[c]
+	for i := range dst {
+		dst[i] ^= row[src[i]]
+	}
+}
+
+func (f *binary4) Scale(dst []byte, c uint32) {
+	if c == 1 {
+		return
+	}
+	row := &f.mul[c]
+	for i := range dst {
+		dst[i] = row[dst[i]]
+	}
+}
+
+func (f *binary4) CoefficientsSize(n int) int { return (n + 1) / 2 }
+
+func (f *binary4) Coefficient(buf []byte, i int) uint32 {
+	return uint32(buf[i/2]>>uint(4*(i%2))) & 0xf
+}
+
+func (f *binary4) SetCoefficient(buf []byte, i int, c uint32) {
+	buf[i/2] &^= 0xf << uint(4*(i%2))
+	buf[i/2] |= byte(c&0xf) << uint(4*(i%2))
+}
+
+// binary8 multiplies with a full table, so MulAdd takes one lookup per byte
+type binary8 struct {
+	exp [510]byte
+	log [256]int
+	mul [256][256]byte
+}
+
+func newBinary8() *binary8 {
+	f := new(binary8)
+	x := 1
+	for i := 0; i < 255; i++ {
+		f.exp[i] = byte(x)
+		f.exp[i+255] = byte(x)
+		f.log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			f.mul[a][b] = f.exp[f.log[a]+f.log[b]]
+		}
+	}
+	return f
+}
+
+func (f *binary8) Name() string { return "binary8" }
+
+func (f *binary8) Order() uint32 { return 256 }
+
+func (f *binary8) Mul(a, b uint32) uint32 { return uint32(f.mul[a][b]) }
+
+func (f *binary8) Inv(a uint32) uint32 { return uint32(f.exp[255-f.log[a]]) }
+
+func (f *binary8) MulAdd(dst, src []byte, c uint32) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+		return
+	}
+	row := &f.mul[c]
+	for i := range dst {
+		dst[i] ^= row[src[i]]
+	}
+}
+
+func (f *binary8) Scale(dst []byte, c uint32) {
+	if c == 1 {
+		return
+	}
+	row := &f.mul[c]
+	for i := range dst {
+		dst[i] = row[dst[i]]
+	}
+}
+
+func (f *binary8) CoefficientsSize(n int) int { return n }
+
+func (f *binary8) Coefficient(buf []byte, i int) uint32 { return uint32(buf[i]) }
+
+func (f *binary8) SetCoefficient(buf []byte, i int, c uint32) { buf[i] = byte(c) }
+
+// binary16 multiplies with logarithm tables, since a full table would take
+// 8 GB
+type binary16 struct {
+	exp [2 * 65535]uint16
+	log [65536]int32
+}
+
+func newBinary16() *binary16 {
+	f := new(binary16)
+	x := 1
+	for i := 0; i < 65535; i++ {
+		f.exp[i] = uint16(x)
+		f.exp[i+65535] = uint16(x)
+		f.log[x] = int32(i)
+		x <<= 1
+		if x&0x10000 != 0 {
+			x ^= 0x1100b
+		}
+	}
+	return f
+}
+
+func (f *binary16) Name() string { return "binary16" }
+
+func (f *binary16) Order() uint32 { return 65536 }
+
+func (f *binary16) Mul(a, b uint32) uint32 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return uint32(f.exp[f.log[a]+f.log[b]])
+}
+
+func (f *binary16) Inv(a uint32) uint32 { return uint32(f.exp[65535-f.log[a]]) }
+
+func (f *binary16) MulAdd(dst, src []byte, c uint32) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+		return
+	}
+	lc := f.log[c]
+	for i := 0; i+1 < len(dst); i += 2 {
+		s := uint16(src[i])<<8 | uint16(src[i+1])
+		if s == 0 {
+			continue
+		}
+		p := f.exp[lc+f.log[s]]
+		dst[i] ^= byte(p >> 8)
+		dst[i+1] ^= byte(p)
+	}
+}
+
+func (f *binary16) Scale(dst []byte, c uint32) {
+	if c == 1 {
+		return
+	}
+	for i := 0; i+1 < len(dst); i += 2 {
+		p := uint16(f.Mul(c, uint32(dst[i])<<8|uint32(dst[i+1])))
+		dst[i] = byte(p >> 8)
+		dst[i+1] = byte(p)
+	}
+}
+
+func (f *binary16) CoefficientsSize(n int) int { return 2 * n }
+
+func (f *binary16) Coefficient(buf []byte, i int) uint32 {
+	return uint32(buf[2*i])<<8 | uint32(buf[2*i+1])
+}
+
+func (f *binary16) SetCoefficient(buf []byte, i int, c uint32) {
+	buf[2*i] = byte(c >> 8)
+	buf[2*i+1] = byte(c)
+}
@@ -0,0 +1,153 @@
+package rlnc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// slowMul multiplies a and b in GF(2^bits) modulo poly bit by bit
+func slowMul(a, b, poly uint32, bits uint) uint32 {
+	var p uint32
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		a <<= 1
+		if a&(1<<bits) != 0 {
+			a ^= poly
+		}
+	}
+	return p
+}
+
+// fields are the fields under test with their polynomials and bits per element
+var fields = []struct {
+	f    Field
+	poly uint32
+	bits uint
+}{
+	{Binary, 0x3, 1},
+	{Binary4, 0x13, 4},
+	{Binary8, 0x11d, 8},
+	{Binary16, 0x1100b, 16},
+}
+
+func TestFieldMul(t *testing.T) {
+	tests := []struct {
+		f       Field
+		a, b, p uint32
+	}{
+		{Binary, 1, 1, 1},
+		{Binary, 1, 0, 0},
+		{Binary4, 2, 8, 3},   // x*x^3 = x+1
+		{Binary4, 9, 9, 0xd}, // (x^3+1)^2 = x^6+1 = x^3+x^2+1
+		{Binary8, 2, 0x80, 0x1d},
+		{Binary8, 2, 0x8e, 1},
+		{Binary8, 0xff, 0xff, 0xe2},
+		{Binary16, 2, 0x8000, 0x100b},
+		{Binary16, 0x100, 0x100, 0x100b},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Mul(tt.a, tt.b); got != tt.p {
+			t.Errorf("%s: %#x * %#x = %#x, want %#x", tt.f.Name(), tt.a, tt.b, got, tt.p)
+		}
+		if got := tt.f.Mul(tt.b, tt.a); got != tt.p {
+			t.Errorf("%s: %#x * %#x = %#x, want %#x", tt.f.Name(), tt.b, tt.a, got, tt.p)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, fl := range fields {
+		for i := 0; i < 20000; i++ {
+			a, b := uint32(rng.Intn(int(fl.f.Order()))), uint32(rng.Intn(int(fl.f.Order())))
+			if got, want := fl.f.Mul(a, b), slowMul(a, b, fl.poly, fl.bits); got != want {
+				t.Fatalf("%s: %#x * %#x = %#x, want %#x", fl.f.Name(), a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestFieldInvDiv(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, fl := range fields {
+		f := fl.f
+		for a := uint32(1); a < f.Order(); a++ {
+			inv := f.Inv(a)
+			if f.Mul(a, inv) != 1 {
+				t.Fatalf("%s: %#x * Inv(%#x) = %#x, want 1", f.Name(), a, a, f.Mul(a, inv))
+			}
+			// Division is the product by the inverse
+			b := uint32(rng.Intn(int(f.Order())))
+			if q := f.Mul(b, inv); f.Mul(q, a) != b {
+				t.Fatalf("%s: (%#x / %#x) * %#x = %#x", f.Name(), b, a, a, f.Mul(q, a))
+			}
+		}
+	}
+}
+
+func TestFieldMulAddScale(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, fl := range fields {
+		f := fl.f
+		src, dst := make([]byte, 64), make([]byte, 64)
+		rng.Read(src)
+		rng.Read(dst)
+		for i := 0; i < 50; i++ {
+			c := 1 + uint32(rng.Intn(int(f.Order()-1)))
+
+			// dst + c*src + c*src = dst
+			got := append([]byte(nil), dst...)
+			f.MulAdd(got, src, c)
+			f.MulAdd(got, src, c)
+			if !bytes.Equal(got, dst) {
+				t.Fatalf("%s: adding c*src twice changed dst", f.Name())
+			}
+
+			// 0 + c*src = c*src, and scaling back by 1/c gives src
+			scaled := make([]byte, len(src))
+			f.MulAdd(scaled, src, c)
+			want := append([]byte(nil), src...)
+			f.Scale(want, c)
+			if !bytes.Equal(scaled, want) {
+				t.Fatalf("%s: MulAdd into zero and Scale by %#x differ", f.Name(), c)
+			}
+			f.Scale(scaled, f.Inv(c))
+			if !bytes.Equal(scaled, src) {
+				t.Fatalf("%s: scaling by %#x and its inverse changed src", f.Name(), c)
+			}
+		}
+	}
+
+	// In GF(2^8) every byte is an element
+	src := []byte{0, 1, 2, 0x80, 0xff}
+	dst := make([]byte, len(src))
+	Binary8.MulAdd(dst, src, 2)
+	for i := range src {
+		if want := byte(Binary8.Mul(uint32(src[i]), 2)); dst[i] != want {
+			t.Errorf("binary8: MulAdd element %d = %#x, want %#x", i, dst[i], want)
+		}
+	}
+}
+
+func TestFieldCoefficients(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, fl := range fields {
+		f := fl.f
+		const n = 13
+		coeffs := make([]uint32, n)
+		buf := make([]byte, f.CoefficientsSize(n))
+		for i := range coeffs {
+			coeffs[i] = uint32(rng.Intn(int(f.Order())))
+			f.SetCoefficient(buf, i, coeffs[i])
+		}
+		for i, c := range coeffs {
+			if got := f.Coefficient(buf, i); got != c {
+				t.Errorf("%s: coefficient %d = %#x, want %#x", f.Name(), i, got, c)
+			}
+		}
+	}
+	if FieldByName("binary16") != Binary16 || FieldByName("binary3") != nil {
+		t.Error("FieldByName does not find the fields by name")
+	}
+}
@@ -0,0 +1,347 @@
+package rlnc
+
+import (
+	"math/rand"
+)
+
+// windowHeaderSize is the size of the index of the first symbol of the window
+// that precedes the coefficients of a sliding-window payload
+const windowHeaderSize = 4
+
+// putWindowStart writes the index of the first symbol of the window at the
+// beginning of a payload, in big-endian order
+func putWindowStart(payload []byte, lo uint32) {
+	payload[0] = byte(lo >> 24)
+	payload[1] = byte(lo >> 16)
+	payload[2] = byte(lo >> 8)
+	payload[3] = byte(lo)
+}
+
+// windowStart reads the index of the first symbol of the window of a payload
+func windowStart(payload []byte) uint32 {
+	return uint32(payload[0])<<24 | uint32(payload[1])<<16 | uint32(payload[2])<<8 | uint32(payload[3])
+}
+
+// WindowEncoder codes a stream of symbols over a sliding window instead of
+// blocks. Every payload holds the index of the first symbol of the window, the
+// packed coefficients of the window symbols and their random combination. The
+// window holds at most Window symbols and slides forward as the receivers
+// deliver them, letting the next symbols of the stream in.
+type WindowEncoder struct {
+	field      Field
+	symbols    int
+	symbolSize int
+	window     int
+	data       []byte
+	lo         int // First symbol of the window
+	rng        *rand.Rand
+}
+
+// NewWindowEncoder creates an encoder for a stream of the given number of
+// symbols of symbolSize bytes, coded over a window of at most window symbols
+func NewWindowEncoder(field Field, symbols, symbolSize, window uint32) *WindowEncoder {
+	return &WindowEncoder{
+		field:      field,
+		symbols:    int(symbols),
+		symbolSize: int(symbolSize),
+		window:     int(window),
+		rng:        rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// SetConstSymbols sets the stream to encode, which must be BlockSize bytes
+// long. The encoder keeps a reference to it.
+func (e *WindowEncoder) SetConstSymbols(data []byte) {
+	e.data = data[:e.BlockSize()]
+}
+
+// Slide moves the beginning of the window to the symbol lo, e.g., once all the
+// previous ones are delivered. The window never moves backwards.
+func (e *WindowEncoder) Slide(lo uint32) {
+	if int(lo) > e.symbols {
+		lo = uint32(e.symbols)
+	}
+	if int(lo) > e.lo {
+		e.lo = int(lo)
+	}
+}
+
+// Window returns the first symbol of the window and the one after the last
+func (e *WindowEncoder) Window() (lo, hi uint32) {
+	hi = uint32(e.lo + e.window)
+	if int(hi) > e.symbols {
+		hi = uint32(e.symbols)
+	}
+	return uint32(e.lo), hi
+}
+
+// WritePayload writes a random combination of the window symbols to the
+// beginning of payload, which must hold at least PayloadSize bytes, and
+// returns the bytes written
+func (e *WindowEncoder) WritePayload(payload []byte) uint32 {
+	payload = payload[:e.PayloadSize()]
+	for i := range payload {
+		payload[i] = 0
+	}
+	lo, hi := e.Window()
+	putWindowStart(payload, lo)
+	coeffs := payload[windowHeaderSize:]
+	symbol := coeffs[e.field.CoefficientsSize(e.window):]
+	for i, c := range randomCoefficients(e.field, e.rng, int(hi-lo)) {
+		e.field.SetCoefficient(coeffs, i, c)
+		e.field.MulAdd(symbol, e.symbol(int(lo)+i), c)
+	}
+	return uint32(len(payload))
+}
+
+// PayloadSize returns the size of the coded payloads
+func (e *WindowEncoder) PayloadSize() uint32 {
+	return uint32(windowHeaderSize + e.field.CoefficientsSize(e.window) + e.symbolSize)
+}
+
+// Rank returns the number of symbols in the window, which is zero once the
+// whole stream is delivered or before it is set
+func (e *WindowEncoder) Rank() uint32 {
+	if e.data == nil {
+		return 0
+	}
+	lo, hi := e.Window()
+	return hi - lo
+}
+
+// Symbols returns the number of symbols of the stream
+func (e *WindowEncoder) Symbols() uint32 { return uint32(e.symbols) }
+
+// SymbolSize returns the size of a symbol in bytes
+func (e *WindowEncoder) SymbolSize() uint32 { return uint32(e.symbolSize) }
+
+// BlockSize returns the size of the whole stream in bytes
+func (e *WindowEncoder) BlockSize() uint32 { return uint32(e.symbols * e.symbolSize) }
+
+// WindowSize returns the maximum number of symbols of the window
+func (e *WindowEncoder) WindowSize() uint32 { return uint32(e.window) }
+
+// symbol returns the i-th symbol of the stream
+func (e *WindowEncoder) symbol(i int) []byte {
+	return e.data[i*e.symbolSize : (i+1)*e.symbolSize]
+}
+
+// WindowDecoder recovers a stream coded by a WindowEncoder and delivers its
+// symbols in order as soon as they are decoded, without waiting for the rest
+// of the stream. The received combinations are kept in row echelon form, and
+// the symbols from the first undelivered one up to the last symbol they
+// depend on are decoded by back substitution once all of them have a pivot. A
+// decoder can also recode the combinations and the symbols it holds, e.g., in
+// a recoder.
+type WindowDecoder struct {
+	field      Field
+	symbols    int
+	symbolSize int
+	window     int
+	data       []byte
+	// Coefficients of the combination with pivot i, from i on. Only the
+	// symbols after the delivered ones have a row.
+	rows      map[int][]uint32
+	base      int // Latest window start seen, the senders need nothing before it
+	delivered int // Symbols decoded in order
+	rng       *rand.Rand
+}
+
+// NewWindowDecoder creates a decoder for a stream of the given number of
+// symbols of symbolSize bytes, coded over a window of at most window symbols
+func NewWindowDecoder(field Field, symbols, symbolSize, window uint32) *WindowDecoder {
+	d := &WindowDecoder{
+		field:      field,
+		symbols:    int(symbols),
+		symbolSize: int(symbolSize),
+		window:     int(window),
+		rows:       make(map[int][]uint32),
+		rng:        rand.New(rand.NewSource(rand.Int63())),
+	}
+	d.data = make([]byte, d.BlockSize())
+	return d
+}
+
+// SetMutableSymbols sets the buffer, BlockSize bytes long, where the stream is
+// decoded. It must be called before the first payload is read.
+func (d *WindowDecoder) SetMutableSymbols(data []byte) {
+	d.data = data[:d.BlockSize()]
+}
+
+// ReadPayload reads a coded payload written by a WindowEncoder or recoded by a
+// WindowDecoder with the same parameters. The combinations of the symbols
+// before the window of the payload are dropped, since every receiver has
+// delivered them. Payloads that are not innovative, that depend on dropped
+// combinations, or whose window reaches past the stream, are discarded.
+func (d *WindowDecoder) ReadPayload(payload []byte) {
+	f := d.field
+	start := int(windowStart(payload))
+	if start > d.symbols {
+		return // Past the stream
+	}
+	if start > d.base {
+		d.base = start
+		for i := range d.rows {
+			if i < d.base {
+				delete(d.rows, i)
+			}
+		}
+	}
+	packed := payload[windowHeaderSize:]
+	coeffs := make([]uint32, d.window)
+	for k := range coeffs {
+		coeffs[k] = f.Coefficient(packed, k)
+	}
+	cs := f.CoefficientsSize(d.window)
+	symbol := make([]byte, d.symbolSize)
+	copy(symbol, packed[cs:cs+d.symbolSize])
+
+	// Subtract the delivered symbols and the known combinations in order, so
+	// the first coefficient left is the pivot of the new row
+	pivot := -1
+	for k := 0; k < len(coeffs); k++ {
+		c := coeffs[k]
+		if c == 0 {
+			continue
+		}
+		i := start + k
+		if i >= d.symbols {
+			return // Past the stream
+		}
+		row, ok := d.rows[i]
+		if i >= d.delivered && !ok {
+			pivot = k
+			break
+		}
+		if i < d.delivered {
+			row = []uint32{1}
+		}
+		if n := k + len(row); n > len(coeffs) {
+			coeffs = append(coeffs, make([]uint32, n-len(coeffs))...)
+		}
+		for j, r := range row {
+			coeffs[k+j] ^= f.Mul(c, r)
+		}
+		f.MulAdd(symbol, d.symbol(i), c)
+	}
+	if pivot < 0 || start+pivot < d.base {
+		return // Not innovative, or no longer needed
+	}
+
+	last := len(coeffs) - 1
+	for coeffs[last] == 0 {
+		last--
+	}
+	row := coeffs[pivot : last+1]
+	inv := f.Inv(row[0])
+	for j := range row {
+		row[j] = f.Mul(inv, row[j])
+	}
+	f.Scale(symbol, inv)
+	d.rows[start+pivot] = row
+	copy(d.symbol(start+pivot), symbol)
+	d.decode()
+}
+
+// decode delivers the symbols that can be decoded after the delivered ones
+func (d *WindowDecoder) decode() {
+	for {
+		row, ok := d.rows[d.delivered]
+		if !ok {
+			return
+		}
+		// All the symbols the first undelivered one depends on, and the ones
+		// they depend on, need a row
+		last := d.delivered + len(row) - 1
+		for i := d.delivered + 1; i <= last; i++ {
+			r, ok := d.rows[i]
+			if !ok {
+				return
+			}
+			if end := i + len(r) - 1; end > last {
+				last = end
+			}
+		}
+		for i := last; i >= d.delivered; i-- {
+			for j, c := range d.rows[i][1:] {
+				d.field.MulAdd(d.symbol(i), d.symbol(i+1+j), c)
+			}
+			delete(d.rows, i)
+		}
+		d.delivered = last + 1
+	}
+}
+
+// WritePayload writes a recoded payload, a random combination of the
+// combinations and the decoded symbols held from the latest window start on,
+// to the beginning of payload, which must hold at least PayloadSize bytes, and
+// returns the bytes written
+func (d *WindowDecoder) WritePayload(payload []byte) uint32 {
+	f := d.field
+	payload = payload[:d.PayloadSize()]
+	for i := range payload {
+		payload[i] = 0
+	}
+	putWindowStart(payload, uint32(d.base))
+	coeffs := make([]uint32, d.window)
+	symbol := payload[windowHeaderSize+f.CoefficientsSize(d.window):]
+	// Everything held from the latest window start on fits in one window,
+	// since it comes from payloads whose window starts at or before it
+	for i := d.base; i < d.base+d.window && i < d.symbols; i++ {
+		row, ok := d.rows[i]
+		if i < d.delivered {
+			row, ok = []uint32{1}, true
+		}
+		if !ok {
+			continue
+		}
+		c := uint32(d.rng.Int63n(int64(f.Order())))
+		for j, r := range row {
+			coeffs[i-d.base+j] ^= f.Mul(c, r)
+		}
+		f.MulAdd(symbol, d.symbol(i), c)
+	}
+	for k, c := range coeffs {
+		f.SetCoefficient(payload[windowHeaderSize:], k, c)
+	}
+	return uint32(len(payload))
+}
+
+// PayloadSize returns the size of the coded payloads
+func (d *WindowDecoder) PayloadSize() uint32 {
+	return uint32(windowHeaderSize + d.field.CoefficientsSize(d.window) + d.symbolSize)
+}
+
+// Rank returns the number of combinations and decoded symbols held from the
+// latest window start on, i.e., the ones WritePayload combines
+func (d *WindowDecoder) Rank() uint32 {
+	rank := len(d.rows)
+	if d.delivered > d.base {
+		rank += d.delivered - d.base
+	}
+	return uint32(rank)
+}
+
+// IsComplete reports whether the whole stream is decoded
+func (d *WindowDecoder) IsComplete() bool { return d.delivered == d.symbols }
+
+// Delivered returns the number of symbols decoded in order, which are at the
+// beginning of the buffer
+func (d *WindowDecoder) Delivered() uint32 { return uint32(d.delivered) }
+
+// Symbols returns the number of symbols of the stream
+func (d *WindowDecoder) Symbols() uint32 { return uint32(d.symbols) }
+
+// SymbolSize returns the size of a symbol in bytes
+func (d *WindowDecoder) SymbolSize() uint32 { return uint32(d.symbolSize) }
+
+// BlockSize returns the size of the whole stream in bytes
+func (d *WindowDecoder) BlockSize() uint32 { return uint32(d.symbols * d.symbolSize) }
+
+// WindowSize returns the maximum number of symbols of the window
+func (d *WindowDecoder) WindowSize() uint32 { return uint32(d.window) }
+
+// symbol returns the i-th symbol of the stream
+func (d *WindowDecoder) symbol(i int) []byte {
+	return d.data[i*d.symbolSize : (i+1)*d.symbolSize]
+}
@@ -0,0 +1,169 @@
+package rlnc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// streamSymbols checks that the symbols delivered by the decoder are the first
+// ones of data
+func streamSymbols(t *testing.T, name string, dec *WindowDecoder, decoded, data []byte) {
+	t.Helper()
+	n := int(dec.Delivered() * dec.SymbolSize())
+	if !bytes.Equal(decoded[:n], data[:n]) {
+		t.Fatalf("%s: the %d symbols delivered differ", name, dec.Delivered())
+	}
+}
+
+func TestWindowDeliveredInOrder(t *testing.T) {
+	const symbols, symbolSize, window = 40, 16, 8
+	rng := rand.New(rand.NewSource(3))
+	for _, fl := range fields {
+		name := fl.f.Name()
+		data := make([]byte, symbols*symbolSize)
+		rng.Read(data)
+		enc := NewWindowEncoder(fl.f, symbols, symbolSize, window)
+		enc.SetConstSymbols(data)
+		dec := NewWindowDecoder(fl.f, symbols, symbolSize, window)
+		decoded := make([]byte, len(data))
+		dec.SetMutableSymbols(decoded)
+
+		for i := 0; !dec.IsComplete(); i++ {
+			if i == 20*symbols {
+				t.Fatalf("%s: %d symbols delivered after %d payloads", name, dec.Delivered(), i)
+			}
+			payload := make([]byte, enc.PayloadSize())
+			payload = payload[:enc.WritePayload(payload)]
+			if rng.Float64() < 0.3 {
+				continue // Lost
+			}
+			before := dec.Delivered()
+			dec.ReadPayload(payload)
+			if dec.Delivered() < before {
+				t.Fatalf("%s: delivered %d symbols, then %d", name, before, dec.Delivered())
+			}
+			streamSymbols(t, name, dec, decoded, data)
+			enc.Slide(dec.Delivered())
+		}
+		if enc.Rank() != 0 {
+			t.Errorf("%s: window of %d symbols left after the stream", name, enc.Rank())
+		}
+	}
+}
+
+// TestWindowRecode feeds a decoder with every other payload of the encoder and
+// the payloads recoded by a WindowDecoder that gets the rest, so the recoded
+// payloads are needed
+func TestWindowRecode(t *testing.T) {
+	const symbols, symbolSize, window = 40, 16, 8
+	rng := rand.New(rand.NewSource(5))
+	for _, fl := range fields {
+		name := fl.f.Name()
+		data := make([]byte, symbols*symbolSize)
+		rng.Read(data)
+		enc := NewWindowEncoder(fl.f, symbols, symbolSize, window)
+		enc.SetConstSymbols(data)
+		rec := NewWindowDecoder(fl.f, symbols, symbolSize, window)
+		dec := NewWindowDecoder(fl.f, symbols, symbolSize, window)
+		decoded := make([]byte, len(data))
+		dec.SetMutableSymbols(decoded)
+
+		recoded := 0
+		for i := 0; !dec.IsComplete(); i++ {
+			if i == 20*symbols {
+				t.Fatalf("%s: %d symbols delivered after %d payloads", name, dec.Delivered(), i)
+			}
+			payload := make([]byte, enc.PayloadSize())
+			payload = payload[:enc.WritePayload(payload)]
+			if i%2 == 0 {
+				rec.ReadPayload(payload)
+			} else {
+				dec.ReadPayload(payload)
+			}
+			if rec.Rank() > 0 {
+				payload = payload[:rec.WritePayload(payload[:cap(payload)])]
+				before := dec.Rank() + dec.Delivered()
+				dec.ReadPayload(payload)
+				if dec.Rank()+dec.Delivered() > before {
+					recoded++
+				}
+			}
+			streamSymbols(t, name, dec, decoded, data)
+			enc.Slide(dec.Delivered()) // The recoder delivers nothing to the stream
+		}
+		if recoded == 0 {
+			t.Errorf("%s: no recoded payload was innovative", name)
+		}
+	}
+}
+
+func TestWindowSlide(t *testing.T) {
+	const symbols, symbolSize, window = 10, 4, 4
+	data := make([]byte, symbols*symbolSize)
+	rand.Read(data)
+	enc := NewWindowEncoder(Binary8, symbols, symbolSize, window)
+	enc.SetConstSymbols(data)
+	dec := NewWindowDecoder(Binary8, symbols, symbolSize, window)
+	write := func() []byte {
+		payload := make([]byte, enc.PayloadSize())
+		return payload[:enc.WritePayload(payload)]
+	}
+	steps := []struct {
+		slide, lo, hi uint32
+	}{
+		{0, 0, 4},
+		{2, 2, 6},
+		{1, 2, 6},  // Never backwards
+		{7, 7, 10}, // Past hi, cut at the end of the stream
+		{12, 10, 10},
+	}
+	for _, s := range steps {
+		enc.Slide(s.slide)
+		if lo, hi := enc.Window(); lo != s.lo || hi != s.hi {
+			t.Errorf("window [%d, %d) after sliding to %d, want [%d, %d)", lo, hi, s.slide, s.lo, s.hi)
+		}
+		if enc.Rank() != s.hi-s.lo {
+			t.Errorf("rank %d after sliding to %d, want %d", enc.Rank(), s.slide, s.hi-s.lo)
+		}
+	}
+
+	// A decoder drops the combinations before the latest window start, and
+	// the payloads that depend on them
+	enc = NewWindowEncoder(Binary8, symbols, symbolSize, window)
+	enc.SetConstSymbols(data)
+	old := write()
+	dec.ReadPayload(write())
+	dec.ReadPayload(write())
+	if dec.Rank() != 2 || dec.Delivered() != 0 {
+		t.Fatalf("rank %d and %d symbols delivered from 2 payloads of [0, 4)", dec.Rank(), dec.Delivered())
+	}
+	enc.Slide(6) // Past hi
+	dec.ReadPayload(write())
+	if dec.Rank() != 1 {
+		t.Errorf("rank %d after a payload of [6, 10), want its combination only", dec.Rank())
+	}
+	dec.ReadPayload(old)
+	if dec.Rank() != 1 {
+		t.Errorf("rank %d after a payload of [0, 4) read late, want it dropped", dec.Rank())
+	}
+
+	// Past the end of the stream, the payloads combine nothing and every
+	// combination held is dropped
+	enc.Slide(symbols)
+	dec.ReadPayload(write())
+	if dec.Rank() != 0 {
+		t.Errorf("rank %d after a payload past the stream, want 0", dec.Rank())
+	}
+
+	// A payload whose window reaches past the stream is discarded
+	bad := write()
+	putWindowStart(bad, symbols-1)
+	Binary8.SetCoefficient(bad[windowHeaderSize:], 1, 1)
+	dec.ReadPayload(bad)
+	putWindowStart(bad, symbols+1)
+	dec.ReadPayload(bad)
+	if dec.Rank() != 0 {
+		t.Errorf("rank %d after payloads past the stream, want them dropped", dec.Rank())
+	}
+}
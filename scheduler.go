@@ -0,0 +1,202 @@
+package mpthSim
+
+import "time"
+
+// PathState is what a node knows about one of its outputs when it schedules
+// its payloads
+type PathState struct {
+	// Loss is the fraction of the payloads the link lost or dropped so far
+	Loss float64
+	// Delay is the delay of the last payload the link delivered, with the
+	// time it waited in the queue, or zero before the first one
+	Delay time.Duration
+	// Sent is the number of payloads the node sent through the link, and
+	// Innovative the number of them the receiver reported as innovative, see
+	// RankFeedback
+	Sent       uint64
+	Innovative uint64
+}
+
+// Scheduler weighs the outputs of a node that still need something. Every
+// output is paced at its own rate, see pace, and sends on average its share
+// relative to the mean share of all of them whenever it is due.
+type Scheduler interface {
+	// Shares returns the share of the payloads of each path, in the same
+	// order. Only their ratios matter, and if they are all zero the payloads
+	// are split equally.
+	Shares(paths []PathState) []float64
+}
+
+// EqualScheduler sends one payload through every output at every tick, like a
+// node without a Scheduler
+type EqualScheduler struct{}
+
+// Shares gives every path the same share
+func (EqualScheduler) Shares(paths []PathState) []float64 {
+	shares := make([]float64, len(paths))
+	for i := range shares {
+		shares[i] = 1
+	}
+	return shares
+}
+
+// LossWeightedScheduler sends more payloads through the paths that lose
+// fewer of them, in proportion to their delivery ratio
+type LossWeightedScheduler struct{}
+
+// Shares gives every path a share proportional to 1 - Loss, counting one more
+// delivered payload out of one more sent so that no path starves
+func (LossWeightedScheduler) Shares(paths []PathState) []float64 {
+	shares := make([]float64, len(paths))
+	for i, p := range paths {
+		sent := float64(p.Sent)
+		shares[i] = ((1-p.Loss)*sent + 1) / (sent + 1)
+	}
+	return shares
+}
+
+// DelayAwareScheduler sends more payloads through the paths with shorter
+// delays, in inverse proportion to them. Since the delays include the time in
+// the queues, it also moves payloads away from congested paths.
+type DelayAwareScheduler struct{}
+
+// Shares gives every path a share proportional to 1 / Delay, with delays
+// shorter than a millisecond counted as one
+func (DelayAwareScheduler) Shares(paths []PathState) []float64 {
+	shares := make([]float64, len(paths))
+	for i, p := range paths {
+		d := p.Delay
+		if d < time.Millisecond {
+			d = time.Millisecond
+		}
+		shares[i] = float64(time.Second) / float64(d)
+	}
+	return shares
+}
+
+// FeedbackScheduler sends more payloads through the paths whose receivers
+// report more of them as innovative, in proportion to that ratio. It needs the
+// receivers to set RankFeedback, and splits the payloads equally until their
+// reports arrive.
+type FeedbackScheduler struct{}
+
+// Shares gives every path a share proportional to the ratio of its payloads
+// that were innovative, counting one more innovative payload out of one more
+// sent so that no path starves
+func (FeedbackScheduler) Shares(paths []PathState) []float64 {
+	shares := make([]float64, len(paths))
+	for i, p := range paths {
+		shares[i] = float64(p.Innovative+1) / float64(p.Sent+1)
+	}
+	return shares
+}
+
+// schedule returns the number of payloads to send through each output at
+// this tick, and sets the time of the next payload of the outputs that are
+// due. Every output that is due and still needs something gets one without a
+// Scheduler. Otherwise the Scheduler gives the shares of all the outputs that
+// still need something, whether they are due or not, since outputs paced at
+// different rates are seldom due together. Every one of them that is due
+// earns its share over the mean share and sends the whole payloads it earned
+// so far. n.mu must be held.
+func (n *Node) schedule() []int {
+	now := n.Clock.Now()
+	counts := make([]int, len(n.OutputLinks))
+	due := make([]bool, len(n.OutputLinks))
+	var live []int
+	var paths []PathState
+	for i, out := range n.OutputLinks {
+		if !out.nextSend.After(now) {
+			due[i] = true
+			out.nextSend = out.nextSend.Add(n.period(out.rate))
+			if !out.nextSend.After(now) {
+				out.nextSend = now.Add(n.period(out.rate)) // Running late
+			}
+		}
+		if n.Scheduler == nil && !due[i] || n.outputDone(out) {
+			continue
+		}
+		live = append(live, i)
+		if n.Scheduler == nil {
+			counts[i] = 1
+		} else {
+			paths = append(paths, n.pathState(out))
+		}
+	}
+	if n.Scheduler == nil || len(live) == 0 {
+		return counts
+	}
+
+	shares := n.Scheduler.Shares(paths)
+	var total float64
+	for _, s := range shares {
+		total += s
+	}
+	for k, i := range live {
+		if !due[i] {
+			continue
+		}
+		earned := 1.0
+		if total > 0 {
+			earned = shares[k] * float64(len(live)) / total
+		}
+		out := n.OutputLinks[i]
+		out.credit += earned
+		counts[i] = int(out.credit)
+		out.credit -= float64(counts[i])
+	}
+	return counts
+}
+
+// pathState returns what the node knows about the output out. n.mu must be
+// held.
+func (n *Node) pathState(out *Link) PathState {
+	out.mu.Lock()
+	p := PathState{Delay: out.lastDelay}
+	out.mu.Unlock()
+	s := out.Stats()
+	p.Sent = s.Transmissions
+	if s.In > 0 {
+		p.Loss = float64(s.Lost+s.Dropped) / float64(s.In)
+	}
+
+	if out.Feedback != nil {
+		p.Innovative = uint64(out.fb.innovative)
+	} else if to := out.receiver(); to != nil {
+		p.Innovative = uint64(to.inputInnovative(out))
+	}
+	return p
+}
+
+// pace returns how long the node waits for its next payload, i.e., until its
+// first output is due, or one period at the rate of the node if it has none.
+// Every output sends a payload of a symbol per period at its own rate.
+func (n *Node) pace() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.OutputLinks) == 0 {
+		return n.period(n.rate)
+	}
+	next := n.OutputLinks[0].nextSend
+	for _, out := range n.OutputLinks[1:] {
+		if out.nextSend.Before(next) {
+			next = out.nextSend
+		}
+	}
+	if wait := next.Sub(n.Clock.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// period returns the time it takes to send a symbol at rate B/s. n.mu must be
+// held.
+func (n *Node) period(rate uint64) time.Duration {
+	var size uint32
+	if n.Encoder != nil {
+		size = n.Encoder.SymbolSize()
+	} else {
+		size = n.Decoder.SymbolSize()
+	}
+	return time.Duration(float64(size) / float64(rate) * float64(time.Second))
+}
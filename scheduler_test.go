@@ -0,0 +1,49 @@
+package mpthSim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JuanCabre/mpthSim/rlnc"
+)
+
+// fixedScheduler gives the paths its shares, in order
+type fixedScheduler []float64
+
+func (s fixedScheduler) Shares(paths []PathState) []float64 {
+	return s[:len(paths)]
+}
+
+// TestScheduleSharesAcrossRates checks that the shares weigh outputs paced at
+// different rates, which are seldom due at the same tick
+func TestScheduleSharesAcrossRates(t *testing.T) {
+	c := NewVirtualClock(time.Unix(0, 0))
+	n := NewEncoderNode(NewRLNCEncoderFactory(rlnc.Binary8, 4, 100), 100)
+	n.Clock = c
+	n.Scheduler = fixedScheduler{1, 3}
+	n.AddOutputWithRate(NewLink(0, 0), 100) // Due every second
+	n.AddOutputWithRate(NewLink(0, 0), 200) // Due every half second
+
+	sent := make(chan []int)
+	c.Go(func() {
+		total := make([]int, 2)
+		for {
+			c.Sleep(n.owner, n.pace())
+			if c.Since(time.Unix(0, 0)) > 10*time.Second {
+				break
+			}
+			n.mu.Lock()
+			for i, count := range n.schedule() {
+				total[i] += count
+			}
+			n.mu.Unlock()
+		}
+		sent <- total
+	})
+
+	// Half and one and a half payloads per tick, over the mean share of 2
+	got := <-sent
+	if want := []int{5, 30}; got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("sent %v payloads in 10s, want %v", got, want)
+	}
+}
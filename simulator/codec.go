@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/rlnc"
+)
+
+// Variants of the codecs
+const (
+	fullVectorVariant = "full_vector"
+	sparseVariant     = "sparse"
+	seedVariant       = "seed"
+	sparseSeedVariant = "sparse_seed"
+	perpetualVariant  = "perpetual"
+)
+
+// CodecSpec is the codec of a simmulation: the backend, the finite field, the
+// variant, the density of the sparse variant and the width of the perpetual
+// one. It is given by the corresponding flags, or by the topology, whose
+// empty fields default to the flags.
+type CodecSpec struct {
+	Codec   string
+	Field   string
+	Variant string
+	Density float64 `json:",omitempty"`
+	Width   uint32  `json:",omitempty"`
+}
+
+// defaults fills the empty fields of the spec with the flags, the density and
+// width only for the rlnc codec, and clears the parameters its variant does not
+// use
+func (s *CodecSpec) defaults() {
+	if s.Codec == "" {
+		s.Codec = codec
+	}
+	if s.Field == "" {
+		s.Field = field
+	}
+	if s.Variant == "" {
+		s.Variant = variant
+	}
+	if s.Density == 0 && s.Codec == "rlnc" {
+		s.Density = density
+	}
+	if s.Width == 0 && s.Codec == "rlnc" {
+		s.Width = uint32(width)
+	}
+	if s.Variant != sparseVariant && s.Variant != sparseSeedVariant {
+		s.Density = 0
+	}
+	if s.Variant != perpetualVariant {
+		s.Width = 0
+	}
+}
+
+// backend builds the encoder and decoder factories of a codec, for a topology
+// with recoders or not. The returned function frees them.
+type backend func(spec CodecSpec, symbols, symbolSize uint32, recoders bool) (mpthSim.EncoderFactory, mpthSim.DecoderFactory, func(), error)
+
+// backends are the codecs that can be selected with the codec flag. The kodo
+// backend is only available when built with the kodo tag.
+var backends = map[string]backend{
+	"rlnc": rlncBackend,
+}
+
+// rlncBackend uses the pure-Go codec of package rlnc. The sparse variant sends
+// the indices of the non-zero coefficients and the seed variants the seed they
+// are drawn from, while the recoders send the shorter of the coefficient vector
+// and its non-zero entries.
+func rlncBackend(spec CodecSpec, symbols, symbolSize uint32, recoders bool) (mpthSim.EncoderFactory, mpthSim.DecoderFactory, func(), error) {
+	f, err := rlncField(spec.Field, symbolSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if spec.Density < 0 || spec.Density > 1 {
+		return nil, nil, nil, fmt.Errorf("codec rlnc: density %v not in (0, 1]", spec.Density)
+	}
+	encoderFactory := mpthSim.NewRLNCEncoderFactory(f, symbols, symbolSize)
+	decoderFactory := mpthSim.NewRLNCDecoderFactory(f, symbols, symbolSize)
+	switch spec.Variant {
+	case fullVectorVariant:
+	case sparseVariant, sparseSeedVariant:
+		if spec.Density == 0 {
+			return nil, nil, nil, fmt.Errorf("codec rlnc: variant %s needs a density", spec.Variant)
+		}
+		encoderFactory.Density = spec.Density
+		encoderFactory.Encoding = rlnc.Sparse
+		if spec.Variant == sparseSeedVariant {
+			encoderFactory.Encoding = rlnc.Seed
+		}
+	case seedVariant:
+		encoderFactory.Encoding = rlnc.Seed
+	case perpetualVariant:
+		if spec.Width == 0 {
+			return nil, nil, nil, fmt.Errorf("codec rlnc: perpetual variant needs a width")
+		}
+		encoderFactory.Width = spec.Width
+	default:
+		return nil, nil, nil, fmt.Errorf("codec rlnc: unknown variant %q", spec.Variant)
+	}
+	decoderFactory.Encoding = encoderFactory.Encoding
+	return encoderFactory, decoderFactory, func() {}, nil
+}
+
+// rlncField returns the rlnc field with the given name, checking that symbols
+// of symbolSize bytes hold a whole number of its elements
+func rlncField(name string, symbolSize uint32) (rlnc.Field, error) {
+	f := rlnc.FieldByName(name)
+	if f == nil {
+		return nil, fmt.Errorf("codec rlnc: unknown field %q", name)
+	}
+	if f == rlnc.Binary16 && symbolSize%2 != 0 {
+		return nil, fmt.Errorf("codec rlnc: field binary16 needs an even symbol size")
+	}
+	return f, nil
+}
+
+// newFactories builds the factories of the codec given by spec, for a
+// topology with recoders or not. In sliding-window mode, the codec codes a
+// stream of the given number of symbols.
+func newFactories(spec CodecSpec, streamSymbols uint32, recoders bool) (mpthSim.EncoderFactory, mpthSim.DecoderFactory, func(), error) {
+	if sliding > 0 {
+		return slidingFactories(spec, streamSymbols)
+	}
+	b, ok := backends[spec.Codec]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("flag codec: unknown codec %q", spec.Codec)
+	}
+	return b(spec, uint32(symbols), uint32(symbolSize), recoders)
+}
+
+// slidingFactories builds the factories of the sliding-window codec, which
+// only the full vector variant of the rlnc backend has
+func slidingFactories(spec CodecSpec, streamSymbols uint32) (mpthSim.EncoderFactory, mpthSim.DecoderFactory, func(), error) {
+	if spec.Codec != "rlnc" {
+		return nil, nil, nil, fmt.Errorf("flag sliding: codec %q has no sliding-window mode", spec.Codec)
+	}
+	if spec.Variant != fullVectorVariant {
+		return nil, nil, nil, fmt.Errorf("flag sliding: variant %q has no sliding-window mode", spec.Variant)
+	}
+	f, err := rlncField(spec.Field, uint32(symbolSize))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return mpthSim.NewRLNCWindowEncoderFactory(f, streamSymbols, uint32(symbolSize), uint32(sliding)),
+		mpthSim.NewRLNCWindowDecoderFactory(f, streamSymbols, uint32(symbolSize), uint32(sliding)), func() {}, nil
+}
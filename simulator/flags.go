@@ -4,9 +4,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/JuanCabre/mpthSim"
 )
 
 // Flags
@@ -17,10 +20,102 @@ var symbols uint
 var symbolSize uint
 var rate uint64
 var runs uint
+var virtual bool
+var seed int64
+var traceLoop bool
+var reorder bool
+var geometry string
+var topology string
+var codec string
+var field string
+var variant string
+var density float64
+var width uint
+var systematic bool
+var size uint
+var window uint
+var sliding uint
+var feedback bool
+var rankFeedback bool
+var scheduler string
+var startAt string
+var visibility bool
+var minElevation float64
+var horizon time.Duration
+var visibilityStep time.Duration
+var sampleInterval time.Duration
 
 // Create user defined flags
-type loss []float64           // Loss probabilities
+type loss []lossSpec          // Loss models
 type interval []time.Duration // Delays
+type files []string           // Trace files
+type bitrate []float64        // Link capacities
+type sizes []int              // Queue lengths
+type rates []uint64           // Send rates
+type jitter []jitterSpec      // Jitter distributions
+
+// lossSpec is the loss model of a link as given in the losses flag. A single
+// number p is a Bernoulli model with loss probability p, while ge:p:r:k:h is a
+// Gilbert-Elliott model with the given transition and delivery probabilities.
+type lossSpec struct {
+	kind   string
+	params []float64
+}
+
+// parseLossSpec parses one entry of the losses flag
+func parseLossSpec(value string) (lossSpec, error) {
+	fields := strings.Split(value, ":")
+	spec := lossSpec{kind: "bernoulli"}
+	want := 1
+	if len(fields) > 1 {
+		spec.kind = fields[0]
+		fields = fields[1:]
+		switch spec.kind {
+		case "bernoulli":
+		case "ge":
+			want = 4
+		default:
+			return spec, fmt.Errorf("unknown loss model %q", spec.kind)
+		}
+	}
+	if len(fields) != want {
+		return spec, fmt.Errorf("loss model %q needs %d parameters", spec.kind, want)
+	}
+	for _, f := range fields {
+		p, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return spec, err
+		}
+		if p < 0 || p > 1 {
+			return spec, fmt.Errorf("probability %v out of range [0, 1]", p)
+		}
+		spec.params = append(spec.params, p)
+	}
+	return spec, nil
+}
+
+// model builds a new loss model from the spec. Loss models keep state, so each
+// link must get its own.
+func (s lossSpec) model() mpthSim.LossModel {
+	if s.kind == "ge" {
+		return mpthSim.NewGilbertElliott(s.params[0], s.params[1], s.params[2], s.params[3])
+	}
+	var p float64
+	if len(s.params) > 0 {
+		p = s.params[0]
+	}
+	return mpthSim.NewBernoulli(p)
+}
+
+func (s lossSpec) String() string {
+	if s.kind == "ge" {
+		return fmt.Sprintf("ge:%v:%v:%v:%v", s.params[0], s.params[1], s.params[2], s.params[3])
+	}
+	if len(s.params) == 0 {
+		return "0"
+	}
+	return fmt.Sprint(s.params[0])
+}
 
 func (l *loss) String() string {
 	return fmt.Sprint(*l)
@@ -30,12 +125,16 @@ func (i *interval) String() string {
 	return fmt.Sprint(*i)
 }
 
+func (f *files) String() string {
+	return fmt.Sprint(*f)
+}
+
 func (l *loss) Set(value string) error {
 	if len(*l) > 0 {
 		return errors.New("loss flag already set")
 	}
 	for _, dt := range strings.Split(value, ",") {
-		loss, err := strconv.ParseFloat(dt, 64)
+		loss, err := parseLossSpec(dt)
 		if err != nil {
 			return err
 		}
@@ -58,21 +157,235 @@ func (i *interval) Set(value string) error {
 	return nil
 }
 
+// jitterSpec is the jitter distribution of a link as given in the jitters
+// flag: none (or 0), uniform:min:max, normal:mean:stddev, pareto:scale:shape
+// or empirical:file, where file holds one measured delay per line.
+type jitterSpec struct {
+	kind      string
+	durations []time.Duration
+	shape     float64
+}
+
+// parseJitterSpec parses one entry of the jitters flag
+func parseJitterSpec(value string) (jitterSpec, error) {
+	fields := strings.Split(value, ":")
+	spec := jitterSpec{kind: fields[0]}
+	switch spec.kind {
+	case "", "0", "none":
+		spec.kind = "none"
+		return spec, nil
+	case "uniform", "normal", "pareto":
+		if len(fields) != 3 {
+			return spec, fmt.Errorf("jitter %q needs 2 parameters", spec.kind)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return spec, err
+		}
+		spec.durations = append(spec.durations, d)
+		if spec.kind == "pareto" {
+			spec.shape, err = strconv.ParseFloat(fields[2], 64)
+			if err == nil && spec.shape <= 0 {
+				err = fmt.Errorf("non-positive pareto shape %v", spec.shape)
+			}
+		} else {
+			d, err = time.ParseDuration(fields[2])
+			spec.durations = append(spec.durations, d)
+		}
+		return spec, err
+	case "empirical":
+		if len(fields) != 2 {
+			return spec, errors.New("jitter \"empirical\" needs a file")
+		}
+		data, err := ioutil.ReadFile(fields[1])
+		if err != nil {
+			return spec, err
+		}
+		for _, line := range strings.Fields(string(data)) {
+			d, err := time.ParseDuration(line)
+			if err != nil {
+				return spec, fmt.Errorf("%s: %v", fields[1], err)
+			}
+			spec.durations = append(spec.durations, d)
+		}
+		return spec, nil
+	}
+	return spec, fmt.Errorf("unknown jitter %q", spec.kind)
+}
+
+// model builds a new jitter from the spec, or nil if there is no jitter.
+// Jitters keep their own random source, so each link must get its own.
+func (s jitterSpec) model() mpthSim.Jitter {
+	switch s.kind {
+	case "uniform":
+		return mpthSim.NewUniformJitter(s.durations[0], s.durations[1])
+	case "normal":
+		return mpthSim.NewNormalJitter(s.durations[0], s.durations[1])
+	case "pareto":
+		return mpthSim.NewParetoJitter(s.durations[0], s.shape)
+	case "empirical":
+		return mpthSim.NewEmpiricalJitter(s.durations)
+	}
+	return nil
+}
+
+func (j *jitter) String() string {
+	return fmt.Sprint(*j)
+}
+
+func (j *jitter) Set(value string) error {
+	if len(*j) > 0 {
+		return errors.New("jitter flag already set")
+	}
+	for _, dt := range strings.Split(value, ",") {
+		spec, err := parseJitterSpec(dt)
+		if err != nil {
+			return err
+		}
+		*j = append(*j, spec)
+	}
+	return nil
+}
+
+func (b *bitrate) String() string {
+	return fmt.Sprint(*b)
+}
+
+func (s *sizes) String() string {
+	return fmt.Sprint(*s)
+}
+
+// parseBitrate parses a capacity in bits/s with an optional k, M or G suffix,
+// e.g., 2M
+func parseBitrate(value string) (float64, error) {
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"):
+		mult = 1e3
+	case strings.HasSuffix(value, "M"):
+		mult = 1e6
+	case strings.HasSuffix(value, "G"):
+		mult = 1e9
+	}
+	if mult != 1 {
+		value = value[:len(value)-1]
+	}
+	c, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if c < 0 {
+		return 0, fmt.Errorf("negative bitrate %v", c)
+	}
+	return c * mult, nil
+}
+
+func (b *bitrate) Set(value string) error {
+	if len(*b) > 0 {
+		return errors.New("bitrate flag already set")
+	}
+	for _, dt := range strings.Split(value, ",") {
+		c, err := parseBitrate(dt)
+		if err != nil {
+			return err
+		}
+		*b = append(*b, c)
+	}
+	return nil
+}
+
+func (s *sizes) Set(value string) error {
+	if len(*s) > 0 {
+		return errors.New("sizes flag already set")
+	}
+	for _, dt := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(dt)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("negative size %v", n)
+		}
+		*s = append(*s, n)
+	}
+	return nil
+}
+
+func (r *rates) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *rates) Set(value string) error {
+	if len(*r) > 0 {
+		return errors.New("rates flag already set")
+	}
+	for _, dt := range strings.Split(value, ",") {
+		v, err := strconv.ParseUint(dt, 10, 64)
+		if err != nil {
+			return err
+		}
+		*r = append(*r, v)
+	}
+	return nil
+}
+
+func (f *files) Set(value string) error {
+	if len(*f) > 0 {
+		return errors.New("files flag already set")
+	}
+	*f = strings.Split(value, ",")
+	return nil
+}
+
 var losses loss
 var delays interval
 var resets interval
 var downtimes interval
+var traces files
+var capacities bitrate
+var queues sizes
+var linkRates rates
+var jitters jitter
 
 func init() {
-	flag.Var(&losses, "losses", "comma-separated lists of the loss probabilities of the links")
+	flag.Var(&losses, "losses", "comma-separated lists of the loss models of the links, either a loss probability or ge:p:r:k:h for a Gilbert-Elliott model, e.g., 0.1,ge:0.01:0.3:1:0.2,...")
 	flag.Var(&delays, "delays", "comma-separated lists of the delays of the links, e.g., 50ms,10ms,...")
 	flag.Var(&resets, "resets", "comma-separated lists of the times before resetting the recoders, e.g., 2s, 5s,...")
 	flag.Var(&downtimes, "downtimes", "comma-separated lists of the downtimes of the recoders, e.g., 2s, 5s,...")
+	flag.Var(&traces, "traces", "comma-separated lists of CSV trace files replayed by the links instead of their losses and delays, an empty entry keeps them, e.g., geo.csv,,leo.csv,...")
+	flag.Var(&capacities, "capacities", "comma-separated lists of the capacities of the links in bits/s, 0 is unlimited, e.g., 512k,2M,...")
+	flag.Var(&queues, "queues", "comma-separated lists of the queue lengths of the links in packets, 0 is unlimited, e.g., 50,100,...")
+	flag.Var(&linkRates, "rates", "comma-separated lists of the rates in Bytes/s at which the nodes send through the links, 0 is the rate flag, e.g., 5000,20000,...")
+	flag.Var(&jitters, "jitters", "comma-separated lists of the delay jitters of the links: none, uniform:min:max, normal:mean:stddev, pareto:scale:shape or empirical:file, e.g., uniform:0s:20ms,none,...")
+	flag.BoolVar(&feedback, "feedback", false, "send the ACKs of the decoders back through feedback links with the same losses and delays as the links, instead of stopping the nodes as soon as the decoders are complete")
+	flag.BoolVar(&rankFeedback, "rank", false, "report the ranks of the generations held by the recoders and decoders to the nodes that feed them, which stop sending through a link what its receiver already holds")
+	flag.StringVar(&scheduler, "scheduler", "equal", "how the encoder splits its payloads among its paths: equal, loss, delay or feedback, which needs the rank flag")
+	flag.BoolVar(&reorder, "reorder", false, "let the jitter reorder the packets of a link instead of keeping them in order")
+	flag.StringVar(&topology, "topology", "", "JSON file with the nodes, links and reset schedules of the simmulation, which replaces the per-link and per-recoder flags")
+	flag.StringVar(&geometry, "geometry", "", "JSON file with the endpoints of the links whose delays follow the positions of ground stations, HAPS and satellites")
+	flag.StringVar(&startAt, "start", "", "the UTC time at which the simmulation starts, e.g., 2024-03-01T12:00:00Z, by default the latest TLE epoch of the geometry")
+	flag.BoolVar(&visibility, "visibility", false, "bring the links of the geometry up and down following the visibility between their endpoints")
+	flag.Float64Var(&minElevation, "minElevation", 10, "the elevation mask in degrees of the endpoints on the ground, aircraft and HAPS")
+	flag.DurationVar(&horizon, "horizon", 2*time.Hour, "the length of simulated time over which the visibility windows are computed")
+	flag.DurationVar(&visibilityStep, "visibilityStep", 10*time.Second, "the sampling step of the visibility windows")
+	flag.BoolVar(&traceLoop, "traceLoop", false, "start the traces over when they end instead of losing every further packet")
 
+	flag.StringVar(&codec, "codec", "rlnc", "the codec: rlnc, the pure-Go RLNC, or kodo, if built with the kodo tag")
+	flag.StringVar(&field, "field", "binary8", "the finite field of the codec: binary, binary4, binary8 or binary16")
+	flag.StringVar(&variant, "variant", fullVectorVariant, "the variant of the codec: full_vector, sparse, seed, sparse_seed or perpetual")
+	flag.Float64Var(&density, "density", 0.5, "the probability that a coefficient of the sparse variants is not zero, with rlnc")
+	flag.UintVar(&width, "width", 8, "the number of symbols coded after the pivot by the perpetual variant, with rlnc")
+	flag.BoolVar(&systematic, "systematic", false, "send the symbols uncoded before the coded repair payloads")
 	flag.UintVar(&symbols, "symbols", 40, "The generation size")
 	flag.UintVar(&symbolSize, "symbolSize", 1000, "The symbol size")
+	flag.UintVar(&size, "size", 0, "the size in bytes of the transfer, split into generations of symbols*symbolSize bytes, by default a single generation")
+	flag.UintVar(&window, "window", 1, "the number of generations the encoder sends at once, 0 is all of them")
+	flag.UintVar(&sliding, "sliding", 0, "code the transfer as a stream of symbols over a sliding window of this many symbols instead of generations, 0 codes in generations")
 	flag.Uint64Var(&rate, "rate", 5000, "the transmission rate in Bytes/s")
 	flag.UintVar(&runs, "runs", 1, "the number of runs in the simmulation")
+	flag.BoolVar(&virtual, "virtual", false, "run the simmulation in virtual time instead of real time")
+	flag.Int64Var(&seed, "seed", 0, "the seed of the random number generator, 0 seeds it from the current time")
+	flag.DurationVar(&sampleInterval, "sample", 0, "the interval at which the ranks of the nodes and the packets in flight and sent through the links are sampled during each run, 0 is off")
 }
 
 // Verify if the flags given by the user had the right size. Otherwise, set the
@@ -80,12 +393,32 @@ func init() {
 func verifyFlags() {
 	if len(losses) != 6 {
 		fmt.Println("flag losses: Incorrect size. Setting it up to the default 0.0")
-		losses = make([]float64, 6)
+		losses = make([]lossSpec, 6)
 	}
 	if len(delays) != 6 {
 		fmt.Println("flag delays: Incorrect size. Setting it up to the default 0s")
 		delays = make([]time.Duration, 6)
 	}
+	if len(traces) != 0 && len(traces) != 6 {
+		fmt.Println("flag traces: Incorrect size. Not replaying any trace")
+		traces = nil
+	}
+	if len(capacities) != 0 && len(capacities) != 6 {
+		fmt.Println("flag capacities: Incorrect size. Setting it up to the default 0 (unlimited)")
+		capacities = nil
+	}
+	if len(queues) != 0 && len(queues) != 6 {
+		fmt.Println("flag queues: Incorrect size. Setting it up to the default 0 (unlimited)")
+		queues = nil
+	}
+	if len(linkRates) != 0 && len(linkRates) != 6 {
+		fmt.Println("flag rates: Incorrect size. Setting it up to the default rate")
+		linkRates = nil
+	}
+	if len(jitters) != 0 && len(jitters) != 6 {
+		fmt.Println("flag jitters: Incorrect size. Setting it up to the default none")
+		jitters = nil
+	}
 	if len(resets) != 3 {
 		fmt.Println("flag resets: Incorrect size. Setting it up to the default 0s")
 		resets = make([]time.Duration, 3)
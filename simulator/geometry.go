@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/geo"
+	"github.com/JuanCabre/mpthSim/orbit"
+)
+
+// endpoint is one end of a link in the geometry file. Exactly one of Site,
+// for ground stations and HAPS, Orbit, for satellites on circular orbits, or
+// TLE, for satellites propagated with SGP4, must be set.
+type endpoint struct {
+	Site  *geo.LLA
+	Orbit *geo.CircularOrbit
+	TLE   *tleRef
+
+	sat *orbit.Satellite
+}
+
+// tleRef selects an element set, either by the name of the satellite in a
+// file with several sets or by giving its two lines
+type tleRef struct {
+	File  string
+	Name  string
+	Lines []string
+}
+
+// linkGeometry gives the endpoints of a link whose delay follows their
+// positions
+type linkGeometry struct {
+	From, To endpoint
+}
+
+// loadGeometry reads the geometry file, a JSON array with one entry per link.
+// A null entry keeps the delay given by the delays flag, e.g.,
+//
+//	[{"From": {"Site": {"Lat": 57.0, "Lon": 9.9, "Alt": 10}},
+//	  "To": {"Orbit": {"Altitude": 550e3, "Inclination": 53, "RAAN": 10, "Phase": 20}}},
+//	 {"From": {"Site": {"Lat": 57.0, "Lon": 9.9, "Alt": 10}},
+//	  "To": {"TLE": {"File": "starlink.txt", "Name": "STARLINK-1007"}}},
+//	 null, ...]
+func loadGeometry(path string) ([]*linkGeometry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g []*linkGeometry
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	for _, lg := range g {
+		if lg == nil {
+			continue
+		}
+		if err := lg.From.load(); err != nil {
+			return nil, err
+		}
+		if err := lg.To.load(); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// load checks the endpoint and builds the propagator of its TLE, if any
+func (e *endpoint) load() error {
+	set := 0
+	for _, ok := range []bool{e.Site != nil, e.Orbit != nil, e.TLE != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("geometry: an endpoint needs one of Site, Orbit or TLE")
+	}
+	if e.TLE == nil {
+		return nil
+	}
+
+	var tle *orbit.TLE
+	var err error
+	switch {
+	case len(e.TLE.Lines) == 2:
+		tle, err = orbit.ParseTLE(e.TLE.Name, e.TLE.Lines[0], e.TLE.Lines[1])
+	case e.TLE.File != "":
+		var tles []*orbit.TLE
+		if tles, err = orbit.LoadTLEs(e.TLE.File); err != nil {
+			break
+		}
+		for _, t := range tles {
+			if e.TLE.Name == "" || t.Name == e.TLE.Name {
+				tle = t
+				break
+			}
+		}
+		if tle == nil {
+			err = fmt.Errorf("geometry: no satellite %q in %s", e.TLE.Name, e.TLE.File)
+		}
+	default:
+		err = errors.New("geometry: a TLE needs a File or two Lines")
+	}
+	if err != nil {
+		return err
+	}
+	e.sat, err = orbit.NewSatellite(tle)
+	return err
+}
+
+// latestTLEEpoch returns the latest epoch of the TLEs in the geometry, which
+// is where the propagation is most accurate, or the zero time if there are no
+// TLEs
+func latestTLEEpoch(g []*linkGeometry) time.Time {
+	var latest time.Time
+	for _, lg := range g {
+		if lg == nil {
+			continue
+		}
+		for _, e := range []endpoint{lg.From, lg.To} {
+			if e.sat != nil && e.sat.TLE.Epoch.After(latest) {
+				latest = e.sat.TLE.Epoch
+			}
+		}
+	}
+	return latest
+}
+
+// positioner returns the position of the endpoint. Circular orbits are placed
+// at their Phase at start, the simulated time at which the runs begin.
+func (e endpoint) positioner(start time.Time) geo.Positioner {
+	switch {
+	case e.Site != nil:
+		return *e.Site
+	case e.sat != nil:
+		return e.sat
+	}
+	o := *e.Orbit
+	o.Epoch = start
+	return o
+}
+
+// shifted is a positioner that maps the time of the clock of a run to the
+// simulated time, so that every run sees the same geometry
+type shifted struct {
+	p     geo.Positioner
+	shift time.Duration
+}
+
+func (s shifted) Position(t time.Time) geo.Vec3 {
+	return s.p.Position(t.Add(s.shift))
+}
+
+// delayModel returns the delay model of the link for a run whose clock reads
+// epoch when the simulated time is start
+func (lg *linkGeometry) delayModel(epoch, start time.Time) mpthSim.DelayModel {
+	from, to := lg.From.positioner(start), lg.To.positioner(start)
+	if shift := start.Sub(epoch); shift != 0 {
+		from, to = shifted{from, shift}, shifted{to, shift}
+	}
+	return &mpthSim.GeometricDelay{From: from, To: to}
+}
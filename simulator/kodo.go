@@ -0,0 +1,59 @@
+//go:build kodo
+// +build kodo
+
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/steinwurf/kodo-go/src/kodo"
+
+	"github.com/JuanCabre/mpthSim"
+)
+
+func init() {
+	backends["kodo"] = kodoBackend
+}
+
+// kodoFields maps the names of the field flag to the kodo fields
+var kodoFields = map[string]kodo.FiniteField{
+	"binary":   kodo.Binary,
+	"binary4":  kodo.Binary4,
+	"binary8":  kodo.Binary8,
+	"binary16": kodo.Binary16,
+}
+
+// kodoVariants maps the names of the variant flag to the kodo codes
+var kodoVariants = map[string]kodo.CodeType{
+	fullVectorVariant: kodo.FullVector,
+	sparseVariant:     kodo.SparseFullVector,
+	seedVariant:       kodo.Seed,
+	sparseSeedVariant: kodo.SparseSeed,
+	perpetualVariant:  kodo.Perpetual,
+}
+
+// kodoBackend uses the codes of kodo, with their default density and width,
+// which cannot be set. The decoders of the seed variants cannot recode, so they
+// are rejected in topologies with recoders.
+func kodoBackend(spec CodecSpec, symbols, symbolSize uint32, recoders bool) (mpthSim.EncoderFactory, mpthSim.DecoderFactory, func(), error) {
+	f, ok := kodoFields[spec.Field]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("codec kodo: unknown field %q", spec.Field)
+	}
+	code, ok := kodoVariants[spec.Variant]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("codec kodo: unknown variant %q", spec.Variant)
+	}
+	if spec.Density != 0 || spec.Width != 0 {
+		return nil, nil, nil, fmt.Errorf("codec kodo: variant %s has a fixed density and width", spec.Variant)
+	}
+	if recoders && (code == kodo.Seed || code == kodo.SparseSeed) {
+		return nil, nil, nil, fmt.Errorf("codec kodo: variant %s cannot recode, but the topology has recoders", spec.Variant)
+	}
+	encoderFactory := mpthSim.NewKodoEncoderFactory(code, f, symbols, symbolSize)
+	decoderFactory := mpthSim.NewKodoDecoderFactory(code, f, symbols, symbolSize)
+	return encoderFactory, decoderFactory, func() {
+		encoderFactory.Delete()
+		decoderFactory.Delete()
+	}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/JuanCabre/mpthSim"
+)
+
+// Sample is the state of a run at some time since its start, e.g., to plot
+// how the ranks evolve across a reset or a handover. Rank and Transmissions
+// follow the nodes, see mpthSim.NodeStats, and the Link* fields the links,
+// summed over every link built for each of them, see mpthSim.LinkStats.
+type Sample struct {
+	Time              float64 `json:"Time[s]"`
+	Rank              []uint32
+	Transmissions     []uint64
+	LinkInFlight      []uint64
+	LinkTransmissions []uint64
+}
+
+// takeSample samples the nodes and the links built for each link of the
+// topology while they run
+func takeSample(t time.Duration, nodes []*mpthSim.Node, built [][]*mpthSim.Link) Sample {
+	s := Sample{Time: t.Seconds()}
+	for _, n := range nodes {
+		stats := n.Stats()
+		s.Rank = append(s.Rank, stats.Rank)
+		s.Transmissions = append(s.Transmissions, stats.Transmissions)
+	}
+	for _, links := range built {
+		var inFlight, sent uint64
+		for _, l := range links {
+			stats := l.Stats()
+			inFlight += stats.InFlight()
+			sent += stats.Transmissions
+		}
+		s.LinkInFlight = append(s.LinkInFlight, inFlight)
+		s.LinkTransmissions = append(s.LinkTransmissions, sent)
+	}
+	return s
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/JuanCabre/mpthSim"
+)
+
+// schedulers are the path schedulers of the encoder that can be selected with
+// the scheduler flag
+var schedulers = map[string]mpthSim.Scheduler{
+	"equal":    mpthSim.EqualScheduler{},
+	"loss":     mpthSim.LossWeightedScheduler{},
+	"delay":    mpthSim.DelayAwareScheduler{},
+	"feedback": mpthSim.FeedbackScheduler{},
+}
+
+// newScheduler returns the path scheduler given by the flags. The feedback
+// scheduler needs the ranks reported by the receivers.
+func newScheduler() (mpthSim.Scheduler, error) {
+	s, ok := schedulers[scheduler]
+	if !ok {
+		return nil, fmt.Errorf("flag scheduler: unknown scheduler %q", scheduler)
+	}
+	if scheduler == "feedback" && !rankFeedback {
+		return nil, fmt.Errorf("flag scheduler: the feedback scheduler needs the rank flag")
+	}
+	return s, nil
+}
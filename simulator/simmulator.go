@@ -1,175 +1,613 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
 	"sync"
 	"time"
 
-	"gitlab.com/steinwurf/kodo-go/src/kodo"
-
 	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/geo"
 )
 
 func main() {
 
-	rand.Seed(time.Now().UnixNano()) // Seed the RNG
-
 	flag.Parse()
-	verifyFlags() // Verify if the flags were correctly set
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed) // Seed the RNG
+
+	// An interrupt stops the current run, and the results of the previous ones
+	// are still written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The topology comes either from a file or from the per-link flags
+	var topo *Topology
+	var err error
+	if topology != "" {
+		if geometry != "" {
+			fmt.Println("flag geometry: Ignored, the topology gives the geometry of each link")
+		}
+		topo, err = loadTopology(topology)
+	} else {
+		verifyFlags() // Verify if the flags were correctly set
+		var geometries []*linkGeometry
+		if geometry != "" {
+			if geometries, err = loadGeometry(geometry); err != nil {
+				log.Fatal(err)
+			}
+		}
+		topo, err = defaultTopology(geometries)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	res := simulate(ctx, topo)
+	if res == nil {
+		return
+	}
+	myres, err := json.Marshal(res)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// fmt.Println(string(myres))
+	// End Store results
+	err = ioutil.WriteFile(time.Now().Format("2006-01-02_15:04")+"_simm.json", myres, 0644)
+
+}
+
+// simulate runs the simulation of topo with the settings of the flags, seeded
+// from the global rand, and returns its results, or nil if a decoder got the
+// data wrong. The runs stop early if ctx is cancelled.
+func simulate(ctx context.Context, topo *Topology) *Result {
+	// The transfer is split into generations of one block each, or coded as
+	// a single stream of symbols in sliding-window mode
+	blockSize := symbols * symbolSize
+	if size == 0 {
+		size = blockSize
+	}
+	generations := uint32((size + blockSize - 1) / blockSize)
+	streamSymbols := uint32((size + symbolSize - 1) / symbolSize)
+	if sliding > 0 {
+		generations = 1
+	}
 
 	// The factories
-	encoderFactory := kodo.NewEncoderFactory(kodo.FullVector,
-		kodo.Binary8, uint32(symbols), uint32(symbolSize))
-	decoderFactory := kodo.NewDecoderFactory(kodo.FullVector,
-		kodo.Binary8, uint32(symbols), uint32(symbolSize))
-	// These lines show the API to clean the memory used by the factories
-	defer kodo.DeleteEncoderFactory(encoderFactory)
-	defer kodo.DeleteDecoderFactory(decoderFactory)
+	encoderFactory, decoderFactory, deleteFactories, err := newFactories(topo.CodecSpec, streamSymbols, topo.hasRecoders())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer deleteFactories()
+
+	// The path scheduler of the encoder
+	pathScheduler, err := newScheduler()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	geometries := topo.geometries()
+
+	// The simulated time at which every run starts
+	simStart := latestTLEEpoch(geometries)
+	if startAt != "" {
+		if simStart, err = time.Parse(time.RFC3339, startAt); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if simStart.IsZero() {
+		simStart = time.Unix(0, 0)
+	}
+
+	// The links of the geometry go up and down following their visibility
+	var windows [][]geo.Window
+	if visibility {
+		hasGeometry := false
+		for _, g := range geometries {
+			hasGeometry = hasGeometry || g != nil
+		}
+		if !hasGeometry {
+			log.Fatal("flag visibility: needs a geometry")
+		}
+		if visibilityStep <= 0 {
+			log.Fatal("flag visibilityStep: must be positive")
+		}
+		if horizon <= 0 {
+			log.Fatal("flag horizon: must be positive")
+		}
+		windows = visibilityWindows(geometries, simStart, simStart.Add(horizon))
+	}
+	// attached reports whether the link idx is attached to its nodes at start
+	attached := func(idx int) bool {
+		if idx >= len(windows) {
+			return true
+		}
+		return upAtStart(windows[idx], simStart, geometries[idx] != nil)
+	}
+
+	res := &Result{Seed: seed, Virtual: virtual, Start: simStart, CodecSpec: topo.CodecSpec, Systematic: systematic, Feedback: feedback, RankFeedback: rankFeedback, Scheduler: scheduler}
+	res.Nodes, res.Links = topo.names()
+	res.Hops = topo.hops()
 
-	res := &Result{}
+	res.Window = window
+	res.Sliding = sliding
+	res.SampleInterval = sampleInterval.Seconds()
 
 	for i := uint(0); i < runs; i++ {
 
-		// The links
-		links := make([]*mpthSim.Link, 6)
-		for i := 0; i < 6; i++ {
-			links[i] = mpthSim.NewLink(losses[i], delays[i])
-			go links[i].ProcessPackets()
+		// The clock shared by all links and nodes of the run
+		var clock mpthSim.Clock = mpthSim.RealClock{}
+		if virtual {
+			clock = mpthSim.NewVirtualClock(simStart)
 		}
+		// Hold the clock while the run is set up, so that virtual time does not
+		// advance before all the nodes have started
+		clock.Hold()
+		epoch := clock.Now()
 
-		// Create the encoder node...
-		encoderNode := mpthSim.NewEncoderNode(encoderFactory, rate)
-		// ...add the outputs...
-		for i := range links {
-			if i%2 == 0 {
-				encoderNode.AddOutput(links[i])
+		// The run is over once every link is closed and has delivered its
+		// packets, which is after the decoders are complete if the ACKs take
+		// time to reach the other nodes, and every goroutine of the nodes, the
+		// resets and the visibility windows has returned
+		var linksWg, tasks sync.WaitGroup
+		// goTask runs f in the clock as one of the tasks of the run
+		goTask := func(f func()) {
+			tasks.Add(1)
+			clock.Go(func() {
+				defer tasks.Done()
+				f()
+			})
+		}
+
+		// newPath builds one direction of the link idx
+		newPath := func(idx int) *mpthSim.Link {
+			spec := topo.Links[idx]
+			var l *mpthSim.Link
+			if spec.trace != nil {
+				l = mpthSim.NewTraceLink(spec.trace, traceLoop)
+			} else if spec.Geometry != nil {
+				l = mpthSim.NewLinkWithModels(spec.Loss.model(), spec.Geometry.delayModel(epoch, simStart))
+			} else {
+				l = mpthSim.NewLinkWithLoss(spec.Loss.model(), time.Duration(spec.Delay))
+			}
+			l.ID = uint32(idx)
+			l.Clock = clock
+			if spec.Capacity > 0 || spec.Queue > 0 {
+				l.SetCapacity(float64(spec.Capacity), spec.Queue)
+			}
+			if j := spec.Jitter.model(); j != nil || reorder {
+				l.SetJitter(j, reorder)
 			}
+			linksWg.Add(1)
+			go func() {
+				defer linksWg.Done()
+				l.ProcessPackets(ctx) // Fails only if ctx is cancelled
+			}()
+			return l
 		}
-		// ...and fill the encoder with random data
-		for i := range encoderNode.Data {
-			encoderNode.Data[i] = uint8(rand.Uint32())
+		// newLink builds the link idx, and its feedback link in the other
+		// direction with the same models if the feedback is on. Every link
+		// built for idx, e.g., after a reset, is kept for its counters.
+		// builtMu guards built, which the sampler reads while the run goes.
+		built := make([][]*mpthSim.Link, len(topo.Links))
+		var builtMu sync.Mutex
+		newLink := func(idx int) *mpthSim.Link {
+			l := newPath(idx)
+			if feedback {
+				l.Feedback = newPath(idx)
+			}
+			builtMu.Lock()
+			built[idx] = append(built[idx], l)
+			builtMu.Unlock()
+			return l
 		}
-		encoderNode.SetConstSymbols()
 
-		// Create the recoder nodes
-		var recoders []*mpthSim.Node
-		linkCount := 0
-		for i := 0; i < 3; i++ {
-			recoders = append(recoders, mpthSim.NewRecoderNode(decoderFactory, rate))
-			recoders[i].NodeID = byte(i)
-			recoders[i].AddInput(links[linkCount])
-			recoders[i].AddOutput(links[linkCount+1])
-			linkCount += 2
+		// The run is done once every decoder is done and drained, see
+		// OnDrained, or ctx is cancelled. Ending it stops the nodes still
+		// sending, e.g., a recoder whose links are all down, which no decoder
+		// tells to stop.
+		runCtx, endRun := context.WithCancel(ctx)
+		runDone := runCtx.Done()
+		var drained mpthSim.Counter
+
+		// The nodes. The ID of each node is its index in the topology, so the
+		// decoders count the packets received from each of them.
+		nodes := make([]*mpthSim.Node, len(topo.Nodes))
+		var delivered []time.Time
+		// In sliding-window mode, the times at which the symbols enter the
+		// window of the encoder and are delivered by the first decoder
+		var entered, deliveredSymbols []time.Time
+		// The time of the last delivery of each decoder, when it completes
+		var completed []*time.Time
+		var encoderNode *mpthSim.Node
+		var decoders []int
+		for idx, spec := range topo.Nodes {
+			var n *mpthSim.Node
+			switch spec.Type {
+			case encoderType:
+				n = mpthSim.NewEncoderNode(encoderFactory, spec.Rate)
+				n.SetGenerations(generations)
+				n.Window = uint32(window)
+				n.Scheduler = pathScheduler
+				// Fill the encoder with random data, the padding of the last
+				// generation is left zero
+				for i := range n.Data[:size] {
+					n.Data[i] = uint8(rand.Uint32())
+				}
+				n.SetConstSymbols()
+				if err := n.SetSystematic(systematic); err != nil {
+					log.Fatal(err)
+				}
+				n.OnEnterWindow = func(i uint32) {
+					entered = append(entered, clock.Now())
+				}
+				encoderNode = n
+			case recoderType:
+				n = mpthSim.NewRecoderNode(decoderFactory, spec.Rate)
+				n.SetGenerations(generations)
+			case decoderType:
+				n = mpthSim.NewDecoderNode(decoderFactory, spec.Rate)
+				n.SetGenerations(generations)
+				last, first := new(time.Time), len(decoders) == 0
+				completed = append(completed, last)
+				n.OnDeliver = func(g uint32, data []byte) {
+					*last = clock.Now()
+					if first {
+						// The delivery times of the first decoder
+						delivered = append(delivered, *last)
+					}
+				}
+				if first {
+					n.OnDeliverSymbol = func(i uint32, data []byte) {
+						deliveredSymbols = append(deliveredSymbols, clock.Now())
+					}
+				}
+				n.OnDrained = func() {
+					if drained.Add(1) == uint64(len(decoders)) {
+						endRun()
+					}
+				}
+				decoders = append(decoders, idx)
+			}
+			n.NodeID = uint32(idx)
+			n.Clock = clock
+			n.RankFeedback = rankFeedback
+			nodes[idx] = n
 		}
 
-		// Create the decoder node
-		decoderNode := mpthSim.NewDecoderNode(decoderFactory, rate)
-		for i := range links {
-			if i%2 == 1 {
-				decoderNode.AddInput(links[i])
+		// The links
+		links := make([]*mpthSim.Link, len(topo.Links))
+		for idx := range links {
+			links[idx] = newLink(idx)
+			if attached(idx) {
+				from, to := topo.ends(idx)
+				nodes[to].AddInput(links[idx])
+				nodes[from].AddOutputWithRate(links[idx], topo.Links[idx].Rate)
+			} else {
+				// Not used, the link is built again when it comes up
+				links[idx].Close()
+				if links[idx].Feedback != nil {
+					links[idx].Feedback.Close()
+				}
 			}
 		}
 
+		// Follow the visibility windows of the links until the run is done
+		for idx := 0; idx < len(windows) && idx < len(links); idx++ {
+			if geometries[idx] == nil {
+				continue
+			}
+			idx := idx
+			from, to := topo.ends(idx)
+			up := func() {
+				fmt.Println("Link", res.Links[idx], "up")
+				links[idx] = newLink(idx)
+				nodes[to].AddInput(links[idx])
+				nodes[from].AddOutputWithRate(links[idx], topo.Links[idx].Rate)
+			}
+			down := func() {
+				fmt.Println("Link", res.Links[idx], "down")
+				nodes[from].RemoveOutput(links[idx])
+			}
+			o := mpthSim.NewOwner()
+			goTask(func() {
+				followWindows(clock, o, epoch, simStart, simStart.Add(horizon), windows[idx], up, down, runDone)
+			})
+		}
+
 		var wg sync.WaitGroup
-		wg.Add(1)
-		go decoderNode.ReceiveCodedPackets(&wg, encoderNode.Done,
-			recoders[0].Done, recoders[1].Done, recoders[2].Done)
-		// go decoderNode.ReceiveCodedPackets(&wg, encoderNode.Done, recoder1.Done)
+		for _, d := range decoders {
+			wg.Add(1)
+			// The decoders stop the nodes that feed them through the links
+			go func(n *mpthSim.Node) {
+				defer wg.Done()
+				n.ReceiveCodedPackets(ctx)
+			}(nodes[d])
+		}
 
-		for _, r := range recoders {
-			go r.RecodeAndSend()
+		for idx, n := range nodes {
+			if topo.Nodes[idx].Type == recoderType {
+				n := n
+				goTask(func() { n.RecodeAndSend(runCtx) })
+			}
 		}
 
-		start := time.Now()
-		go encoderNode.SendEncodedPackets()
+		start := clock.Now()
+		goTask(func() { encoderNode.SendEncodedPackets(runCtx) })
 
-		mres := make([]float64, 3)
-		mdown := make([]float64, 3)
+		// Sample the run at every sample interval until every decoder is
+		// done, or the run is, which cuts the sampler's sleep short so that
+		// it does not advance the virtual time while the nodes wind down
+		var samples []Sample
+		decodersDone := func() bool {
+			for _, d := range decoders {
+				select {
+				case <-nodes[d].Done:
+				default:
+					return false
+				}
+			}
+			return true
+		}
+		if sampleInterval > 0 {
+			sampler := mpthSim.NewOwner()
+			goTask(func() {
+				for {
+					builtMu.Lock()
+					samples = append(samples, takeSample(clock.Since(start), nodes, built))
+					builtMu.Unlock()
+					if decodersDone() || !sleep(clock, sampler, sampleInterval, runDone) {
+						return
+					}
+				}
+			})
+		}
+
+		mres := make([]float64, len(topo.Schedules))
+		mdown := make([]float64, len(topo.Schedules))
 		// Reset the recoders after their time expires
-		reseter := func(i int) {
-			if resets[i] == 0 {
+		reseter := func(i int, o *mpthSim.Owner) {
+			s := topo.Schedules[i]
+			if s.Reset == 0 {
 				return
 			}
-			tRes := time.Now()
-			<-time.After(resets[i])
-			tDown := time.Now()
-			mres[i] = time.Since(tRes).Seconds()
-			fmt.Println("Reseting Recoder")
-			recoders[i].Reset(decoderFactory)
-
-			// Input link
-			idx := 2 * i
-			links[idx] = mpthSim.NewLink(losses[idx], delays[idx])
-			go links[idx].ProcessPackets()
-			recoders[i].AddInput(links[idx])
+			r := topo.nodeIdx[s.Node]
+			tRes := clock.Now()
+			if !sleep(clock, o, time.Duration(s.Reset), runDone) {
+				return // No need to reset it anymore
+			}
+			tDown := clock.Now()
+			mres[i] = clock.Since(tRes).Seconds()
+			fmt.Println("Reseting Recoder", s.Node)
+			nodes[r].Reset(decoderFactory)
 
-			// Output link
-			links[idx+1] = mpthSim.NewLink(losses[idx+1], delays[idx+1])
-			go links[idx+1].ProcessPackets()
-			recoders[i].AddOutput(links[idx+1])
+			// Rebuild the links of the recoder, which are attached to the
+			// other ends once the recoder is back
+			var rebuilt []int
+			for idx := range links {
+				from, to := topo.ends(idx)
+				if from != r && to != r {
+					continue
+				}
+				links[idx] = newLink(idx)
+				if to == r {
+					nodes[r].AddInput(links[idx])
+				} else {
+					nodes[r].AddOutputWithRate(links[idx], topo.Links[idx].Rate)
+				}
+				rebuilt = append(rebuilt, idx)
+			}
 
-			<-time.After(downtimes[i])
+			// Cut short at the end of the run, when the recoder still has to
+			// close the links it was given
+			sleep(clock, o, time.Duration(s.Downtime), runDone)
 
-			decoderNode.AddInput(links[idx+1])
-			encoderNode.AddOutput(links[idx])
-			go recoders[i].RecodeAndSend()
-			mdown[i] = time.Since(tDown).Seconds()
+			for _, idx := range rebuilt {
+				from, to := topo.ends(idx)
+				if to == r {
+					nodes[from].AddOutputWithRate(links[idx], topo.Links[idx].Rate)
+				} else {
+					nodes[to].AddInput(links[idx])
+				}
+			}
+			goTask(func() { nodes[r].RecodeAndSend(runCtx) })
+			mdown[i] = clock.Since(tDown).Seconds()
 
 		}
-		for i := range recoders {
-			go reseter(i)
+		for i := range topo.Schedules {
+			i, o := i, mpthSim.NewOwner()
+			goTask(func() { reseter(i, o) })
 		}
 
+		clock.Release() // The run is set up, let the time advance
 		wg.Wait()
+		endRun() // Already ended, see OnDrained
+		// The run took until the last decoder completed, not until the
+		// decoders were drained
+		end := start
+		for _, t := range completed {
+			if t.After(end) {
+				end = *t
+			}
+		}
+		tasks.Wait()
+		linksWg.Wait()
+		if err := ctx.Err(); err != nil {
+			fmt.Println("Run", i, "stopped:", err)
+			break
+		}
 
 		// Check if we properly decoded the data
-		for i, v := range encoderNode.Data {
-			if v != decoderNode.Data[i] {
-				fmt.Println("Unexpected failure to decode")
-				fmt.Println("Please file a bug report :)")
-				return
+		for _, d := range decoders {
+			for i, v := range encoderNode.Data[:size] {
+				if v != nodes[d].Data[i] {
+					fmt.Println("Unexpected failure to decode at", topo.Nodes[d].ID)
+					fmt.Println("Please file a bug report :)")
+					return nil
+				}
 			}
 		}
 		fmt.Println("Data decoded correctly")
 
 		// Store results
-		runTime := time.Since(start).Seconds()
+		runTime := end.Sub(start).Seconds()
 		res.Latency = append(res.Latency, runTime)
-		res.RxPackets = append(res.RxPackets, decoderNode.RxPackets)
+		var genLatency []float64
+		for _, t := range delivered {
+			genLatency = append(genLatency, t.Sub(start).Seconds())
+		}
+		res.GenerationLatency = append(res.GenerationLatency, genLatency)
+		if sliding > 0 {
+			symbolLatency := make([]float64, len(deliveredSymbols))
+			for i, t := range deliveredSymbols {
+				symbolLatency[i] = t.Sub(entered[i]).Seconds()
+			}
+			res.SymbolLatency = append(res.SymbolLatency, symbolLatency)
+		}
+		res.Size = append(res.Size, size)
+		res.Generations = append(res.Generations, generations)
+		// The packets received by the first decoder from each node, and
+		// through each path
+		rx := make([]uint32, len(topo.Nodes))
+		var sources []SourceResult
+		for k, s := range nodes[decoders[0]].SourceStats() {
+			rx[k.Source] += uint32(s.Received)
+			sources = append(sources, SourceResult{
+				Source:        res.Nodes[k.Source],
+				Path:          res.Links[k.Path],
+				Received:      s.Received,
+				Innovative:    s.Innovative,
+				NonInnovative: s.NonInnovative,
+				FirstArrival:  s.FirstArrival.Sub(start).Seconds(),
+				LastArrival:   s.LastArrival.Sub(start).Seconds(),
+			})
+		}
+		sort.Slice(sources, func(i, j int) bool {
+			if sources[i].Source != sources[j].Source {
+				return sources[i].Source < sources[j].Source
+			}
+			return sources[i].Path < sources[j].Path
+		})
+		res.RxPackets = append(res.RxPackets, rx)
+		res.Sources = append(res.Sources, sources)
+		// The transmissions of each node, and their sum over the nodes at the
+		// same number of hops from the encoder
+		var transmissions, wasted, feedbacks, saved []uint64
+		perHop := make(map[int]uint64)
+		maxHop := 0
+		for idx, n := range nodes {
+			stats := n.Stats()
+			transmissions = append(transmissions, stats.Transmissions)
+			wasted = append(wasted, stats.Wasted)
+			feedbacks = append(feedbacks, stats.Feedback)
+			saved = append(saved, stats.Saved)
+			if h := res.Hops[idx]; h >= 0 && topo.Nodes[idx].Type != decoderType {
+				perHop[h] += stats.Transmissions
+				if h > maxHop {
+					maxHop = h
+				}
+			}
+		}
+		for h := 0; h <= maxHop; h++ {
+			fmt.Printf("Hop %d transmissions: %d\n", h+1, perHop[h])
+		}
+		res.Transmissions = append(res.Transmissions, transmissions)
+		res.WastedTransmissions = append(res.WastedTransmissions, wasted)
+		res.FeedbackTransmissions = append(res.FeedbackTransmissions, feedbacks)
+		res.SavedTransmissions = append(res.SavedTransmissions, saved)
+		var totalSent, totalWasted, totalSaved uint64
+		for idx := range nodes {
+			totalSent += transmissions[idx]
+			totalWasted += wasted[idx]
+			totalSaved += saved[idx]
+		}
+		fmt.Printf("Wasted transmissions: %d\n", totalWasted)
+		if rankFeedback {
+			// Without the ranks, the saved payloads would have been sent too
+			fmt.Printf("Saved transmissions: %d (%.1f%%)\n", totalSaved,
+				100*float64(totalSaved)/float64(totalSent+totalSaved))
+		}
+		encoderStats := encoderNode.Stats()
+		sys := encoderStats.Systematic
+		coded := encoderStats.Transmissions - sys
+		fmt.Printf("Encoder transmissions: %d systematic, %d coded\n", sys, coded)
+		res.SystematicTransmissions = append(res.SystematicTransmissions, sys)
+		res.CodedTransmissions = append(res.CodedTransmissions, coded)
 		res.Symbols = append(res.Symbols, symbols)
 		res.SymbolSize = append(res.SymbolSize, symbolSize)
-		var ures []float64
-		for _, x := range resets {
-			ures = append(ures, x.Seconds())
+		var ures, udown []float64
+		for _, s := range topo.Schedules {
+			ures = append(ures, time.Duration(s.Reset).Seconds())
+			udown = append(udown, time.Duration(s.Downtime).Seconds())
 		}
 		res.UserResets = append(res.UserResets, ures)
 		res.MeasuredResets = append(res.MeasuredResets, mres)
-		var udown []float64
-		for _, x := range downtimes {
-			udown = append(udown, x.Seconds())
-		}
 		res.UserDowntimes = append(res.UserDowntimes, udown)
 		res.MeasuredDowntimes = append(res.MeasuredDowntimes, mdown)
+		var drops, reordered, linkTransmissions, linkBytes []uint64
+		var meanQueue, goodput []float64
+		var maxQueue []int
+		var totalBytes, totalUseful uint64
+		for idx, l := range links {
+			var sent, bytes, useful uint64
+			for _, b := range built[idx] {
+				stats := b.Stats()
+				sent += stats.Transmissions
+				bytes += stats.InBytes
+				useful += stats.UsefulBytes
+			}
+			linkTransmissions = append(linkTransmissions, sent)
+			linkBytes = append(linkBytes, bytes)
+			if bytes > 0 {
+				goodput = append(goodput, float64(useful)/float64(bytes))
+			} else {
+				goodput = append(goodput, 0)
+			}
+			totalBytes += bytes
+			totalUseful += useful
+			stats := l.Stats()
+			qs := stats.Queue
+			drops = append(drops, stats.Dropped)
+			reordered = append(reordered, stats.Reordered)
+			meanQueue = append(meanQueue, qs.Mean)
+			maxQueue = append(maxQueue, qs.Max)
+		}
+		if totalBytes > 0 {
+			fmt.Printf("Goodput: %.1f%% of %d bytes sent\n", 100*float64(totalUseful)/float64(totalBytes), totalBytes)
+		}
+		res.LinkTransmissions = append(res.LinkTransmissions, linkTransmissions)
+		res.LinkBytes = append(res.LinkBytes, linkBytes)
+		res.LinkGoodput = append(res.LinkGoodput, goodput)
+		res.LinkDrops = append(res.LinkDrops, drops)
+		res.LinkMeanQueue = append(res.LinkMeanQueue, meanQueue)
+		res.LinkMaxQueue = append(res.LinkMaxQueue, maxQueue)
+		res.LinkReordered = append(res.LinkReordered, reordered)
+		if sampleInterval > 0 {
+			res.Samples = append(res.Samples, samples)
+		}
 		res.Run = append(res.Run, i)
 	}
-
-	myres, err := json.Marshal(res)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// fmt.Println(string(myres))
-	// End Store results
-	err = ioutil.WriteFile(time.Now().Format("2006-01-02_15:04")+"_simm.json", myres, 0644)
-
+	return res
 }
 
 type Result struct {
 	Run               []uint
+	Seed              int64
+	Virtual           bool
+	Start             time.Time
+	Nodes             []string // IDs of the nodes, RxPackets is indexed by node
+	Links             []string // Names of the links, the Link* results follow this order
 	Symbols           []uint
 	SymbolSize        []uint
 	rate              []uint64
@@ -179,4 +617,67 @@ type Result struct {
 	MeasuredDowntimes [][]float64 `json:"MeasuredDowntimes[s]"`
 	Latency           []float64   `json:"Latency[s]"`
 	RxPackets         [][]uint32  `json:"RxPackets"`
+	Sources           [][]SourceResult
+	Hops              []int       // Hops from the encoder to each node
+	Transmissions     [][]uint64  // Packets sent by each node
+	LinkTransmissions [][]uint64  // Packets sent through each link
+	LinkBytes         [][]uint64  // Bytes sent through each link, headers included
+	LinkGoodput       [][]float64 // Ratio of LinkBytes that were innovative symbols
+	LinkDrops         [][]uint64  `json:"LinkDrops"`
+	LinkMeanQueue     [][]float64 `json:"LinkMeanQueue[packets]"`
+	LinkMaxQueue      [][]int     `json:"LinkMaxQueue[packets]"`
+	LinkReordered     [][]uint64  `json:"LinkReordered"`
+
+	// Size of the transfer in bytes, its generations, the generations in
+	// flight and the time at which each generation is delivered in order
+	Size              []uint
+	Generations       []uint32
+	Window            uint
+	GenerationLatency [][]float64 `json:"GenerationLatency[s]"`
+
+	// Window of the sliding-window mode in symbols, 0 if off, and the time
+	// from the moment each symbol enters the window of the encoder until it
+	// is delivered in order
+	Sliding       uint
+	SymbolLatency [][]float64 `json:"SymbolLatency[s]"`
+
+	// Whether the ACKs go through feedback links, the payloads each node sent
+	// to nodes whose decoders were all complete and the feedback messages
+	// each node sent
+	Feedback              bool
+	WastedTransmissions   [][]uint64
+	FeedbackTransmissions [][]uint64
+
+	// Whether the recoders and decoders report the ranks they hold, and the
+	// payloads each node did not send because its receivers held them
+	RankFeedback       bool
+	SavedTransmissions [][]uint64
+
+	// Path scheduler of the encoder
+	Scheduler string
+
+	// Codec, field and variant of the coders, with the density of the sparse
+	// variant or the width of the perpetual one
+	CodecSpec
+
+	// Systematic mode of the encoder and payloads it sent uncoded and coded
+	Systematic              bool
+	SystematicTransmissions []uint64
+	CodedTransmissions      []uint64
+
+	// Interval at which the runs are sampled, 0 if off, and the samples of
+	// each run, see Sample
+	SampleInterval float64 `json:"SampleInterval[s]"`
+	Samples        [][]Sample
+}
+
+// SourceResult are the packets the first decoder received from a node through
+// a link, and the times at which the first and the last one arrived
+type SourceResult struct {
+	Source, Path  string
+	Received      uint64
+	Innovative    uint64
+	NonInnovative uint64
+	FirstArrival  float64 `json:"FirstArrival[s]"`
+	LastArrival   float64 `json:"LastArrival[s]"`
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// TestSimulateDeterministic checks that a seeded simulation in virtual time
+// gives the same results whatever the number of threads that run it
+func TestSimulateDeterministic(t *testing.T) {
+	// The same delays over every link, so that many timers expire at the same
+	// instant
+	losses, delays = nil, nil // Set again if the test is repeated
+	err := flag.CommandLine.Parse([]string{
+		"-virtual", "-feedback", "-runs", "2", "-sample", "100ms",
+		"-losses", "0.1,0.2,0.1,0.3,0.1,0.1",
+		"-delays", "10ms,10ms,10ms,10ms,10ms,10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyFlags()
+
+	simulateWith := func(procs int) *Result {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		topo, err := defaultTopology(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rand.Seed(7)
+		res := simulate(context.Background(), topo)
+		if res == nil {
+			t.Fatalf("GOMAXPROCS=%d: failed to decode", procs)
+		}
+		return res
+	}
+	one, many := simulateWith(1), simulateWith(8)
+	if !reflect.DeepEqual(one, many) {
+		t.Errorf("results differ with GOMAXPROCS=1 and 8, e.g., transmissions %v and %v",
+			one.Transmissions, many.Transmissions)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/JuanCabre/mpthSim"
+)
+
+// Types of the nodes of a topology
+const (
+	encoderType = "encoder"
+	recoderType = "recoder"
+	decoderType = "decoder"
+)
+
+// Topology describes the nodes of a simmulation, the links between them and
+// the schedule of the recoder resets. It is read from the JSON file given in
+// the topology flag, e.g.,
+//
+//	{
+//	  "Field": "binary16", "Variant": "sparse", "Density": 0.2,
+//	  "Nodes": [
+//	    {"ID": "enc", "Type": "encoder"},
+//	    {"ID": "geo", "Type": "recoder"},
+//	    {"ID": "leo", "Type": "recoder", "Rate": 10000},
+//	    {"ID": "dec", "Type": "decoder"}
+//	  ],
+//	  "Links": [
+//	    {"From": "enc", "To": "geo", "Loss": 0.01, "Delay": "125ms", "Rate": 2000},
+//	    {"From": "geo", "To": "dec", "Loss": "ge:0.01:0.3:1:0.2", "Delay": "125ms"},
+//	    {"From": "enc", "To": "leo", "Capacity": "2M", "Queue": 50,
+//	     "Geometry": {"From": {"Site": {"Lat": 57, "Lon": 10}},
+//	                  "To": {"TLE": {"File": "leo.txt", "Name": "SAT-1"}}}},
+//	    {"From": "leo", "To": "dec", "Trace": "leo.csv", "Jitter": "uniform:0s:5ms"}
+//	  ],
+//	  "Schedules": [{"Node": "leo", "Reset": "20s", "Downtime": "2s"}]
+//	}
+//
+// The codec fields and the link fields follow the syntax of the corresponding
+// flags, which give the codec fields that are missing. There must be exactly
+// one encoder and at least one decoder, every recoder must lead to a decoder
+// and the links must not form a loop.
+type Topology struct {
+	CodecSpec
+
+	Nodes     []*NodeSpec
+	Links     []*LinkSpec
+	Schedules []*ScheduleSpec
+
+	nodeIdx map[string]int
+}
+
+// NodeSpec is a node of the topology. Rate defaults to the rate flag.
+type NodeSpec struct {
+	ID   string
+	Type string
+	Rate uint64
+}
+
+// LinkSpec is a link of the topology. Trace takes precedence over Loss and
+// Delay, and Geometry over Delay. Rate is the rate in B/s at which From sends
+// through the link, by default the rate of From.
+type LinkSpec struct {
+	From, To string
+	Rate     uint64
+	Loss     lossSpec
+	Delay    duration
+	Trace    string
+	Capacity bits
+	Queue    int
+	Jitter   jitterSpec
+	Geometry *linkGeometry
+
+	trace *mpthSim.Trace
+}
+
+// ScheduleSpec resets a recoder after Reset and keeps it down for Downtime,
+// as the resets and downtimes flags do. A zero Reset never resets it.
+type ScheduleSpec struct {
+	Node     string
+	Reset    duration
+	Downtime duration
+}
+
+// duration is a time.Duration given as a string in JSON, e.g., "250ms"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+// bits is a capacity in bits/s given either as a number or as a string with
+// an optional k, M or G suffix, e.g., "2M"
+type bits float64
+
+func (c *bits) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var f float64
+		if err := json.Unmarshal(b, &f); err != nil {
+			return err
+		}
+		s = fmt.Sprint(f)
+	}
+	v, err := parseBitrate(s)
+	*c = bits(v)
+	return err
+}
+
+// UnmarshalJSON reads a loss model either as a loss probability or as a
+// string with the syntax of the losses flag
+func (s *lossSpec) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		var f float64
+		if err := json.Unmarshal(b, &f); err != nil {
+			return err
+		}
+		v = fmt.Sprint(f)
+	}
+	spec, err := parseLossSpec(v)
+	*s = spec
+	return err
+}
+
+// UnmarshalJSON reads a jitter with the syntax of the jitters flag
+func (s *jitterSpec) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	spec, err := parseJitterSpec(v)
+	*s = spec
+	return err
+}
+
+// loadTopology reads and checks a topology file
+func loadTopology(path string) (*Topology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := new(Topology)
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := t.init(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, l := range t.Links {
+		if l.Geometry == nil {
+			continue
+		}
+		if err := l.Geometry.From.load(); err != nil {
+			return nil, err
+		}
+		if err := l.Geometry.To.load(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// defaultTopology builds the topology given by the flags: an encoder sending
+// to a decoder through three recoders, where links 2i and 2i+1 go in and out
+// of recoder i
+func defaultTopology(geometries []*linkGeometry) (*Topology, error) {
+	t := new(Topology)
+	t.Nodes = append(t.Nodes, &NodeSpec{ID: "encoder", Type: encoderType})
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprint("recoder", i)
+		t.Nodes = append(t.Nodes, &NodeSpec{ID: id, Type: recoderType})
+		t.Links = append(t.Links, &LinkSpec{From: "encoder", To: id}, &LinkSpec{From: id, To: "decoder"})
+		t.Schedules = append(t.Schedules, &ScheduleSpec{Node: id,
+			Reset: duration(resets[i]), Downtime: duration(downtimes[i])})
+	}
+	t.Nodes = append(t.Nodes, &NodeSpec{ID: "decoder", Type: decoderType})
+
+	for i, l := range t.Links {
+		l.Loss = losses[i]
+		l.Delay = duration(delays[i])
+		if len(traces) > 0 {
+			l.Trace = traces[i]
+		}
+		if len(capacities) > 0 {
+			l.Capacity = bits(capacities[i])
+		}
+		if len(queues) > 0 {
+			l.Queue = queues[i]
+		}
+		if len(linkRates) > 0 {
+			l.Rate = linkRates[i]
+		}
+		if len(jitters) > 0 {
+			l.Jitter = jitters[i]
+		}
+		if i < len(geometries) {
+			l.Geometry = geometries[i]
+		}
+	}
+	return t, t.init()
+}
+
+// init checks the topology, fills in the defaults and loads the traces of the
+// links
+func (t *Topology) init() error {
+	t.CodecSpec.defaults()
+	t.nodeIdx = make(map[string]int)
+	encoders, decoders := 0, 0
+	for i, n := range t.Nodes {
+		if _, ok := t.nodeIdx[n.ID]; ok {
+			return fmt.Errorf("topology: duplicated node %q", n.ID)
+		}
+		t.nodeIdx[n.ID] = i
+		switch n.Type {
+		case encoderType:
+			encoders++
+		case decoderType:
+			decoders++
+		case recoderType:
+		default:
+			return fmt.Errorf("topology: node %q has unknown type %q", n.ID, n.Type)
+		}
+		if n.Rate == 0 {
+			n.Rate = rate
+		}
+	}
+	if encoders != 1 || decoders == 0 {
+		return errors.New("topology: needs exactly one encoder and at least one decoder")
+	}
+
+	for i, l := range t.Links {
+		from, okFrom := t.nodeIdx[l.From]
+		to, okTo := t.nodeIdx[l.To]
+		if !okFrom || !okTo {
+			return fmt.Errorf("topology: link %d connects unknown nodes %q and %q", i, l.From, l.To)
+		}
+		if t.Nodes[from].Type == decoderType || t.Nodes[to].Type == encoderType {
+			return fmt.Errorf("topology: link %d goes out of a decoder or into the encoder", i)
+		}
+		if l.Loss.kind == "" {
+			l.Loss.kind = "bernoulli"
+		}
+		if l.Rate == 0 {
+			l.Rate = t.Nodes[from].Rate
+		}
+		if l.Trace != "" {
+			var err error
+			if l.trace, err = mpthSim.LoadTrace(l.Trace); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The nodes find out whether a generation is done downstream by asking
+	// the nodes they send to, which would never end in a loop
+	if t.hasCycle() {
+		return errors.New("topology: the links form a loop")
+	}
+
+	for i, n := range t.Nodes {
+		if n.Type == recoderType && len(t.reachableDecoders(i)) == 0 {
+			return fmt.Errorf("topology: recoder %q does not lead to any decoder", n.ID)
+		}
+	}
+	if len(t.reachableDecoders(t.encoder())) == 0 {
+		return errors.New("topology: the encoder does not lead to any decoder")
+	}
+
+	for _, s := range t.Schedules {
+		idx, ok := t.nodeIdx[s.Node]
+		if !ok || t.Nodes[idx].Type != recoderType {
+			return fmt.Errorf("topology: schedule of %q, which is not a recoder", s.Node)
+		}
+	}
+	return nil
+}
+
+// encoder returns the index of the encoder node
+func (t *Topology) encoder() int {
+	for i, n := range t.Nodes {
+		if n.Type == encoderType {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasRecoders reports whether the topology has any recoder
+func (t *Topology) hasRecoders() bool {
+	for _, n := range t.Nodes {
+		if n.Type == recoderType {
+			return true
+		}
+	}
+	return false
+}
+
+// reachableDecoders returns the indices of the decoders that node can reach
+// through the links
+func (t *Topology) reachableDecoders(node int) []int {
+	var decoders []int
+	seen := make(map[int]bool)
+	stack := []int{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		if t.Nodes[n].Type == decoderType {
+			decoders = append(decoders, n)
+		}
+		for _, l := range t.Links {
+			if t.nodeIdx[l.From] == n {
+				stack = append(stack, t.nodeIdx[l.To])
+			}
+		}
+	}
+	return decoders
+}
+
+// hasCycle reports whether a node can reach itself through the links
+func (t *Topology) hasCycle() bool {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(t.Nodes))
+	var visit func(n int) bool
+	visit = func(n int) bool {
+		state[n] = visiting
+		for _, l := range t.Links {
+			if t.nodeIdx[l.From] != n {
+				continue
+			}
+			switch to := t.nodeIdx[l.To]; state[to] {
+			case visiting:
+				return true
+			case unvisited:
+				if visit(to) {
+					return true
+				}
+			}
+		}
+		state[n] = visited
+		return false
+	}
+	for n := range t.Nodes {
+		if state[n] == unvisited && visit(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// geometries returns the geometry of each link, nil for the links without one
+func (t *Topology) geometries() []*linkGeometry {
+	g := make([]*linkGeometry, len(t.Links))
+	for i, l := range t.Links {
+		g[i] = l.Geometry
+	}
+	return g
+}
+
+// ends returns the indices of the nodes at both ends of the link idx
+func (t *Topology) ends(idx int) (from, to int) {
+	return t.nodeIdx[t.Links[idx].From], t.nodeIdx[t.Links[idx].To]
+}
+
+// names returns the IDs of the nodes and the names of the links, e.g.,
+// "encoder->recoder0"
+func (t *Topology) names() (nodes, links []string) {
+	for _, n := range t.Nodes {
+		nodes = append(nodes, n.ID)
+	}
+	for _, l := range t.Links {
+		links = append(links, l.From+"->"+l.To)
+	}
+	return nodes, links
+}
+
+// hops returns the number of hops from the encoder to each node, i.e., the
+// length of the shortest chain of links, or -1 for the nodes out of reach
+func (t *Topology) hops() []int {
+	hops := make([]int, len(t.Nodes))
+	for i := range hops {
+		hops[i] = -1
+	}
+	queue := []int{t.encoder()}
+	hops[queue[0]] = 0
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, l := range t.Links {
+			to := t.nodeIdx[l.To]
+			if t.nodeIdx[l.From] == n && hops[to] < 0 {
+				hops[to] = hops[n] + 1
+				queue = append(queue, to)
+			}
+		}
+	}
+	return hops
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JuanCabre/mpthSim"
+	"github.com/JuanCabre/mpthSim/geo"
+)
+
+// visibilityWindows computes the visibility windows of the links with a
+// geometry between start and end in simulated time. Links without a geometry
+// get no windows and are always up.
+func visibilityWindows(g []*linkGeometry, start, end time.Time) [][]geo.Window {
+	windows := make([][]geo.Window, len(g))
+	for i, lg := range g {
+		if lg == nil {
+			continue
+		}
+		v := geo.Visibility{
+			From:         lg.From.positioner(start),
+			To:           lg.To.positioner(start),
+			MinElevation: minElevation,
+			MinAltitude:  80e3,
+		}
+		windows[i] = v.Windows(start, end, visibilityStep)
+		fmt.Printf("link %d visibility windows:", i)
+		for _, w := range windows[i] {
+			fmt.Printf(" [%v, %v]", w.Start.Sub(start), w.End.Sub(start))
+		}
+		fmt.Println()
+	}
+	return windows
+}
+
+// upAtStart reports whether a link with the given windows is up when the
+// simulation starts
+func upAtStart(windows []geo.Window, start time.Time, hasGeometry bool) bool {
+	if !hasGeometry {
+		return true
+	}
+	return len(windows) > 0 && !windows[0].Start.After(start)
+}
+
+// followWindows brings a link up and down as its visibility windows open and
+// close. The windows are in simulated time, which is start when the clock of
+// the run reads epoch. A link up at start must already be attached, and a
+// window still open at end is left open. It returns early once done is closed.
+// Its timers are set on behalf of o.
+func followWindows(clock mpthSim.Clock, o *mpthSim.Owner, epoch, start, end time.Time,
+	windows []geo.Window, up, down func(), done <-chan struct{}) {
+
+	sleepUntil := func(t time.Time) bool {
+		return sleep(clock, o, t.Sub(start)-clock.Since(epoch), done)
+	}
+
+	for _, w := range windows {
+		if w.Start.After(start) {
+			if !sleepUntil(w.Start) {
+				return
+			}
+			up()
+		}
+		if !w.End.Before(end) {
+			return
+		}
+		if !sleepUntil(w.End) {
+			return
+		}
+		down()
+	}
+}
+
+// sleep is clock.Sleep, but it returns early once done is closed, and reports
+// whether done is still open. The caller must be held in clock.
+func sleep(clock mpthSim.Clock, o *mpthSim.Owner, d time.Duration, done <-chan struct{}) bool {
+	woke := make(chan struct{})
+	left := make(chan struct{})
+	clock.AfterFunc(o, d, func() {
+		clock.Hold() // Handed over to the sleeper, unless it left
+		select {
+		case woke <- struct{}{}:
+		case <-left:
+			clock.Release()
+		}
+	})
+	clock.Release()
+	select {
+	case <-woke:
+	case <-done:
+		close(left)
+		clock.Hold()
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
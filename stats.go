@@ -0,0 +1,153 @@
+package mpthSim
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Counter counts events of a run. It may be read by any goroutine while
+// others add to it, e.g., to sample the state of a run as it goes.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Add adds delta to the counter and returns the new count
+func (c *Counter) Add(delta uint64) uint64 {
+	return c.v.Add(delta)
+}
+
+// Load returns the count
+func (c *Counter) Load() uint64 {
+	return c.v.Load()
+}
+
+// LinkStats is a snapshot of the counters of a link, see Link.Stats
+type LinkStats struct {
+	Transmissions                     uint64 // Payloads sent by the sender
+	In, Out, Lost, Dropped, Reordered uint64 // Packets, see Link.InCount
+	InBytes, UsefulBytes              uint64
+	Queue                             QueueStats
+}
+
+// InFlight returns the packets that entered the link and are neither
+// delivered, lost nor dropped yet, i.e., that are being delayed
+func (s LinkStats) InFlight() uint64 {
+	return s.In - s.Out - s.Lost - s.Dropped
+}
+
+// Goodput returns the ratio of the bytes sent through the link that were
+// useful to the receiver, or zero if nothing was sent
+func (s LinkStats) Goodput() float64 {
+	if s.InBytes == 0 {
+		return 0
+	}
+	return float64(s.UsefulBytes) / float64(s.InBytes)
+}
+
+// Stats returns the counters of the link. It may be called while packets flow.
+// Every packet is counted in In before it is counted as delivered, lost or
+// dropped, so In is read last and InFlight is never negative.
+func (l *Link) Stats() LinkStats {
+	s := LinkStats{
+		Transmissions: l.Transmissions.Load(),
+		Out:           l.OutCount.Load(),
+		Lost:          l.LostCount.Load(),
+		Dropped:       l.DroppedCount.Load(),
+		Reordered:     l.ReorderedCount.Load(),
+		UsefulBytes:   l.UsefulBytes.Load(),
+		Queue:         l.QueueStats(),
+	}
+	s.In = l.InCount.Load()
+	s.InBytes = l.InBytes.Load()
+	return s
+}
+
+// NodeStats is a snapshot of the counters of a node, see Node.Stats
+type NodeStats struct {
+	// Payloads sent through all the outputs the node ever had, see
+	// Node.Transmissions, and the wasted, feedback, saved and systematic
+	// ones, see the counters of Node
+	Transmissions, Wasted, Feedback, Saved, Systematic uint64
+	// Generations and symbols delivered in order by a decoder
+	Delivered, DeliveredSymbols uint32
+	// Rank is the sum of the ranks of the coders of the node, i.e., the
+	// symbols it holds of the generations it still keeps
+	Rank uint32
+}
+
+// Stats returns the counters of the node. It may be called while the node
+// runs.
+func (n *Node) Stats() NodeStats {
+	n.mu.Lock()
+	s := NodeStats{
+		Delivered:        n.Delivered,
+		DeliveredSymbols: n.DeliveredSymbols,
+	}
+	for _, out := range n.outputs {
+		s.Transmissions += out.Transmissions.Load()
+	}
+	for _, e := range n.encoders {
+		s.Rank += e.Rank()
+	}
+	for _, d := range n.decoders {
+		s.Rank += d.Rank()
+	}
+	n.mu.Unlock()
+	s.Wasted = n.WastedTransmissions.Load()
+	s.Feedback = n.FeedbackTransmissions.Load()
+	s.Saved = n.SavedTransmissions.Load()
+	s.Systematic = n.SystematicTransmissions.Load()
+	return s
+}
+
+// SourceKey identifies the packets a node receives from the same source
+// through the same path, see Header
+type SourceKey struct {
+	Source uint32
+	Path   uint32
+}
+
+// SourceStats are the statistics of the packets a node received from a source
+// through a path. Received is the sum of Innovative and NonInnovative, where
+// the non-innovative packets include the ones of generations the node no
+// longer needed.
+type SourceStats struct {
+	Received      uint64
+	Innovative    uint64
+	NonInnovative uint64
+	FirstArrival  time.Time
+	LastArrival   time.Time
+}
+
+// SourceStats returns the statistics of the packets received by the node from
+// every source and path
+func (n *Node) SourceStats() map[SourceKey]SourceStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	stats := make(map[SourceKey]SourceStats, len(n.sources))
+	for k, s := range n.sources {
+		stats[k] = *s
+	}
+	return stats
+}
+
+// recordPacket counts a packet with the header h received by the node, which
+// was innovative or not. n.mu must be held.
+func (n *Node) recordPacket(h Header, innovative bool) {
+	k := SourceKey{Source: h.Source, Path: h.Path}
+	s, ok := n.sources[k]
+	if !ok {
+		s = &SourceStats{FirstArrival: n.Clock.Now()}
+		if n.sources == nil {
+			n.sources = make(map[SourceKey]*SourceStats)
+		}
+		n.sources[k] = s
+	}
+	s.Received++
+	if innovative {
+		s.Innovative++
+	} else {
+		s.NonInnovative++
+	}
+	s.LastArrival = n.Clock.Now()
+}
@@ -0,0 +1,185 @@
+package mpthSim
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TraceRecord is one packet of a recorded trace
+type TraceRecord struct {
+	// Offset is the time of the packet since the beginning of the trace
+	Offset    time.Duration
+	Delivered bool
+	// Delay is the one-way delay of the packet if it was delivered
+	Delay time.Duration
+}
+
+// Trace is a recorded packet trace of a real channel, sorted by time. A trace
+// is never modified once read, so several links can replay the same one.
+type Trace struct {
+	Records []TraceRecord
+}
+
+// LoadTrace reads a trace from a CSV file. See ReadTrace for the format.
+func LoadTrace(path string) (*Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := ReadTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// ReadTrace reads a trace in CSV format with one packet per line:
+//
+//	timestamp,delivered,delay
+//
+// The timestamp and the delay are either seconds, e.g., 0.25, or durations,
+// e.g., 250ms. delivered is 1/0 or true/false, and the delay of lost packets
+// may be left empty. An optional header line and lines starting with # are
+// ignored. Timestamps are made relative to the first packet.
+func ReadTrace(r io.Reader) (*Trace, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	t := new(Trace)
+	var first time.Duration
+	for line := 1; ; line++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected timestamp,delivered,delay", line)
+		}
+
+		ts, err := parseTraceTime(fields[0])
+		if err != nil {
+			if line == 1 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		delivered, err := strconv.ParseBool(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rec := TraceRecord{Delivered: delivered}
+		if delivered {
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: missing delay of a delivered packet", line)
+			}
+			if rec.Delay, err = parseTraceTime(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+
+		if len(t.Records) == 0 {
+			first = ts
+		}
+		rec.Offset = ts - first
+		if n := len(t.Records); n > 0 && rec.Offset < t.Records[n-1].Offset {
+			return nil, fmt.Errorf("line %d: timestamps are not sorted", line)
+		}
+		t.Records = append(t.Records, rec)
+	}
+
+	if len(t.Records) == 0 {
+		return nil, errors.New("empty trace")
+	}
+	if t.Period() == 0 {
+		return nil, errors.New("every packet of the trace has the same timestamp")
+	}
+	return t, nil
+}
+
+// parseTraceTime parses either a number of seconds or a duration string
+func parseTraceTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Period returns the length of the trace when it is looped. The last packet
+// is assumed to last as long as the gap that precedes it, so it is zero if
+// every packet has the same timestamp.
+func (t *Trace) Period() time.Duration {
+	n := len(t.Records)
+	if n < 2 {
+		return time.Second
+	}
+	last := t.Records[n-1].Offset
+	return last + last - t.Records[n-2].Offset
+}
+
+// NewTraceLink creates a link that replays the trace instead of using a fixed
+// loss model and delay. A packet entering the link gets the fate and the delay
+// of the last recorded packet at the same time since the first packet that
+// entered the link. When the trace ends, it starts over if loop is true, and
+// otherwise every further packet is lost.
+func NewTraceLink(t *Trace, loop bool) *Link {
+	r := &traceReplay{trace: t, loop: loop}
+	return NewLinkWithModels(r, r)
+}
+
+// traceReplay implements both LossModel and DelayModel for a trace. Links
+// query it from ProcessPackets only, so it needs no locking.
+type traceReplay struct {
+	trace   *Trace
+	loop    bool
+	start   time.Time
+	started bool
+	pos     int
+}
+
+// record returns the trace record in effect at time now
+func (r *traceReplay) record(now time.Time) TraceRecord {
+	if !r.started {
+		r.start = now
+		r.started = true
+	}
+	recs := r.trace.Records
+	offset := now.Sub(r.start)
+	if period := r.trace.Period(); offset >= period {
+		if !r.loop {
+			return TraceRecord{Offset: offset} // Lost
+		}
+		if period > 0 { // Otherwise the last packet lasts forever
+			offset %= period
+		}
+	}
+	if offset < recs[r.pos].Offset {
+		r.pos = 0 // The trace started over
+	}
+	for r.pos+1 < len(recs) && recs[r.pos+1].Offset <= offset {
+		r.pos++
+	}
+	return recs[r.pos]
+}
+
+// Lost reports whether the record in effect at time now was lost
+func (r *traceReplay) Lost(now time.Time) bool {
+	return !r.record(now).Delivered
+}
+
+// Delay returns the delay of the record in effect at time now
+func (r *traceReplay) Delay(now time.Time) time.Duration {
+	return r.record(now).Delay
+}
@@ -0,0 +1,69 @@
+package mpthSim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		period  time.Duration
+		wantErr bool
+	}{
+		{"seconds", "timestamp,delivered,delay\n10,1,0.05\n10.5,0,\n11,true,60ms\n", 1500 * time.Millisecond, false},
+		{"single packet", "0,1,10ms\n", time.Second, false},
+		{"empty", "# nothing\n", 0, true},
+		{"same timestamps", "2,1,10ms\n2,0,\n2,1,10ms\n", 0, true},
+		{"unsorted", "1,1,10ms\n0,1,10ms\n", 0, true},
+		{"missing delay", "0,1\n", 0, true},
+	}
+	for _, tt := range tests {
+		tr, err := ReadTrace(strings.NewReader(tt.csv))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && tr.Period() != tt.period {
+			t.Errorf("%s: period %v, want %v", tt.name, tr.Period(), tt.period)
+		}
+	}
+}
+
+func TestTraceReplayLoop(t *testing.T) {
+	tr := &Trace{Records: []TraceRecord{
+		{Offset: 0, Delivered: true, Delay: 10 * time.Millisecond},
+		{Offset: time.Second, Delivered: false},
+	}}
+	start := time.Unix(0, 0)
+	r := &traceReplay{trace: tr, loop: true}
+	tests := []struct {
+		at   time.Duration
+		lost bool
+	}{{0, false}, {1500 * time.Millisecond, true}, {2 * time.Second, false}, {3 * time.Second, true}}
+	for _, tt := range tests {
+		if lost := r.Lost(start.Add(tt.at)); lost != tt.lost {
+			t.Errorf("at %v: lost %v, want %v", tt.at, lost, tt.lost)
+		}
+	}
+
+	once := &traceReplay{trace: tr}
+	once.Lost(start)
+	if !once.Lost(start.Add(5 * time.Second)) {
+		t.Error("a packet after the end of a trace that does not loop was delivered")
+	}
+}
+
+func TestTraceReplayZeroPeriod(t *testing.T) {
+	// Built by hand, ReadTrace rejects it
+	tr := &Trace{Records: []TraceRecord{{Delivered: true}, {Delivered: true}}}
+	r := &traceReplay{trace: tr, loop: true}
+	start := time.Unix(0, 0)
+	for _, at := range []time.Duration{0, time.Second, time.Hour} {
+		if r.Lost(start.Add(at)) {
+			t.Errorf("at %v: lost, want the last packet replayed", at)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package mpthSim
+
+// In sliding-window mode, the encoder and the decoders hold a windowEncoder
+// and windowDecoder, e.g., built by the RLNCWindow factories. The whole stream
+// is a single generation, and the window of the encoder slides forward as the
+// decoders deliver the symbols in order.
+
+// slideWindow moves the window of a sliding-window encoder past the symbols
+// delivered by every decoder downstream and calls OnEnterWindow with the
+// symbols that enter it. It does nothing for block encoders. n.mu must be
+// held.
+func (n *Node) slideWindow() {
+	e, ok := n.Encoder.(windowEncoder)
+	if !ok {
+		return
+	}
+	e.Slide(n.downstreamDelivered())
+	_, hi := e.Window()
+	for ; n.entered < hi; n.entered++ {
+		if n.OnEnterWindow != nil {
+			n.OnEnterWindow(n.entered)
+		}
+	}
+}
+
+// symbolsDelivered returns the number of symbols delivered in order by the
+// node, if it is a decoder, or by every decoder downstream otherwise
+func (n *Node) symbolsDelivered() uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.downstreamDelivered()
+}
+
+// downstreamDelivered is symbolsDelivered with n.mu held. The answer never
+// decreases, so the nodes whose outputs are being rebuilt, e.g., during a
+// reset, keep the last one.
+func (n *Node) downstreamDelivered() uint32 {
+	delivered := n.DeliveredSymbols // Only set at a decoder
+	if len(n.OutputLinks) > 0 {
+		delivered = ^uint32(0)
+		for _, out := range n.OutputLinks {
+			d := out.fb.delivered
+			if out.Feedback == nil {
+				to := out.receiver()
+				if to == nil {
+					return n.acked
+				}
+				d = to.symbolsDelivered()
+			}
+			if d < delivered {
+				delivered = d
+			}
+		}
+	}
+	if delivered > n.acked {
+		n.acked = delivered
+	}
+	return n.acked
+}
+
+// deliverSymbols hands the symbols decoded in order by a sliding-window
+// decoder to OnDeliverSymbol. It does nothing for block decoders. n.mu must be
+// held.
+func (n *Node) deliverSymbols(d Decoder) {
+	w, ok := d.(windowDecoder)
+	if !ok {
+		return
+	}
+	size := d.SymbolSize()
+	for ; n.DeliveredSymbols < w.Delivered(); n.DeliveredSymbols++ {
+		if n.OnDeliverSymbol != nil {
+			i := n.DeliveredSymbols
+			n.OnDeliverSymbol(i, n.Data[i*size:(i+1)*size])
+		}
+	}
+}